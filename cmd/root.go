@@ -1,24 +1,42 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os/signal"
 	"regexp"
 	"strings"
+	"text/template"
 
 	"os"
 
+	humanize "github.com/dustin/go-humanize"
+	"github.com/vbaksa/promoter/config"
+	"github.com/vbaksa/promoter/connection"
 	"github.com/vbaksa/promoter/image"
+	"github.com/vbaksa/promoter/layer"
+	"github.com/vbaksa/promoter/manifest"
+	"github.com/vbaksa/promoter/reference"
+	"github.com/vbaksa/promoter/rewrite"
+	"github.com/vbaksa/promoter/semver"
 	"github.com/vbaksa/promoter/tags"
 
-	"errors"
-
 	"github.com/spf13/cobra"
 )
 
 var (
 	version = "DEV"
+	//output receives progress and warnings printed by commands
+	output io.Writer = os.Stdout
 )
 
+//defaultPruneMax limits number of tags deleted by --prune unless --prune-max is specified
+const defaultPruneMax = 10
+
 // RootCmd provides CLI handler for the application
 var RootCmd = &cobra.Command{
 	Use:   "promoter",
@@ -36,12 +54,45 @@ func init() {
 	var srcPassword string
 	var destUsername string
 	var destPassword string
+	var srcPasswordFile string
+	var destPasswordFile string
+	var srcPasswordStdin bool
+	var destPasswordStdin bool
 	var debug bool
 	var srcInsecure bool
 	var destInsecure bool
+	var srcCAFile string
+	var srcCertFile string
+	var srcKeyFile string
+	var destCAFile string
+	var destCertFile string
+	var destKeyFile string
 	var srcHTTP bool
+	var insecureRegistries []string
+	var configFile string
 	var destHTTP bool
 	var tagRegexp string
+	var tagExclude string
+	var semverRange string
+	var latest int
+	var latestBy string
+	var dryRun bool
+	var tagTemplate string
+	var tagRewrite string
+	var force bool
+	var prune bool
+	var pruneMax int
+	var platform string
+	var chunkSize string
+	var limitRate string
+	var limitDownloadRate string
+	var limitUploadRate string
+	var syncFile string
+	var includeRepositories []string
+	var excludeRepositories []string
+	var repositoryRewrite string
+	var concurrency connection.Concurrency
+	var retryAttempts int
 
 	var versionCmd = &cobra.Command{
 		Use:   "version",
@@ -61,45 +112,100 @@ func init() {
 				fmt.Println("Missing command arguments, usage: push [registry/image/tag] [registry/image/tag]")
 				os.Exit(1)
 			}
-			srcRegistry, srcImage, srcImageTag, err := ImageNameAndRegistryAndTag(args[0])
+			cfg, err := config.Load(configFile)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			srcRef, srcScheme, err := parseReference(cfg, args[0], false, false)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			destRef, destScheme, err := parseReference(cfg, args[1], false, true)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			if destRef.Digest != "" {
+				fmt.Println("Destination image reference must specify a tag, not a digest")
+				os.Exit(1)
+			}
+			srcRegistry := registryURL(srcScheme, srcRef.Endpoint(), srcHTTP)
+			destRegistry := registryURL(destScheme, destRef.Endpoint(), destHTTP)
+			srcImageTag := srcRef.Tag
+			if srcImageTag == "" && srcRef.Digest == "" {
+				srcImageTag = reference.DefaultTag
+			}
+			platforms, err := manifest.ParsePlatforms(platform)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+
+			transfer, err := transferOptions(chunkSize, limitRate, limitDownloadRate, limitUploadRate)
 			if err != nil {
 				fmt.Println(err.Error())
 				os.Exit(1)
 			}
-			destRegistry, destImage, destImageTag, err := ImageNameAndRegistryAndTag(args[1])
+			workers, err := concurrencyOptions(cmd, cfg, concurrency)
 			if err != nil {
 				fmt.Println(err.Error())
 				os.Exit(1)
 			}
-			replaceRegistryName(&srcRegistry)
-			replaceRegistryName(&destRegistry)
-			if srcHTTP {
-				addRegistryProtocol(&srcRegistry, false)
-			} else {
-				addRegistryProtocol(&srcRegistry, true)
+			retry, err := retryPolicy(cmd, cfg, retryAttempts)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			if srcPasswordStdin && destPasswordStdin {
+				fmt.Println("Only one of --src-password-stdin and --dest-password-stdin can be used")
+				os.Exit(1)
+			}
+			srcUsername, srcPassword, err = credentials("src", srcUsername, srcPassword, srcPasswordFile, srcPasswordStdin)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
 			}
-			if destHTTP {
-				addRegistryProtocol(&destRegistry, false)
-			} else {
-				addRegistryProtocol(&destRegistry, true)
+			destUsername, destPassword, err = credentials("dest", destUsername, destPassword, destPasswordFile, destPasswordStdin)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
 			}
 
 			prom := &image.Promote{
-				SrcRegistry:  srcRegistry,
-				SrcImage:     srcImage,
-				SrcImageTag:  srcImageTag,
-				SrcUsername:  srcUsername,
-				SrcPassword:  srcPassword,
-				SrcInsecure:  srcInsecure,
-				DestRegistry: destRegistry,
-				DestImage:    destImage,
-				DestImageTag: destImageTag,
-				DestUsername: destUsername,
-				DestPassword: destPassword,
-				DestInsecure: destInsecure,
-				Debug:        debug,
-			}
-			prom.PromoteImage()
+				SrcRegistry:        srcRegistry,
+				SrcImage:           srcRef.Repository,
+				SrcImageTag:        srcImageTag,
+				SrcImageDigest:     srcRef.Digest,
+				SrcUsername:        srcUsername,
+				SrcPassword:        srcPassword,
+				SrcInsecure:        srcInsecure,
+				SrcCAFile:          srcCAFile,
+				SrcCertFile:        srcCertFile,
+				SrcKeyFile:         srcKeyFile,
+				DestRegistry:       destRegistry,
+				DestImage:          destRef.Repository,
+				DestImageTag:       destRef.Reference(),
+				DestUsername:       destUsername,
+				DestPassword:       destPassword,
+				DestInsecure:       destInsecure,
+				DestCAFile:         destCAFile,
+				DestCertFile:       destCertFile,
+				DestKeyFile:        destKeyFile,
+				Platforms:          platforms,
+				Transfer:           transfer,
+				Concurrency:        workers,
+				Retry:              retry,
+				InsecureRegistries: insecureRegistries,
+				SrcProxy:           cfg.Registry(srcRef.Endpoint()).Proxy,
+				DestProxy:          cfg.Registry(destRef.Endpoint()).Proxy,
+				SrcMirrors:         cfg.Registry(srcRef.Endpoint()).Mirrors,
+				Output:             output,
+			}
+			setupLogging(debug)
+			_, err = prom.PromoteImage(commandContext())
+			exit(err)
 
 		},
 	}
@@ -114,50 +220,317 @@ func init() {
 				fmt.Println("Missing command arguments, usage: tags [registry/image] [registry/image]")
 				os.Exit(1)
 			}
-			srcRegistry, srcImage, err := ImageNameAndRegistry(args[0])
+			cfg, err := config.Load(configFile)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			srcRef, srcScheme, err := parseReference(cfg, args[0], true, false)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			destRef, destScheme, err := parseReference(cfg, args[1], true, true)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			srcRegistry := registryURL(srcScheme, srcRef.Endpoint(), srcHTTP)
+			destRegistry := registryURL(destScheme, destRef.Endpoint(), destHTTP)
+			if err := validateTagFilters(tagRegexp, tagExclude, semverRange, latest, latestBy); err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			if err := validateTagMapping(tagTemplate, tagRewrite); err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			platforms, err := manifest.ParsePlatforms(platform)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+
+			transfer, err := transferOptions(chunkSize, limitRate, limitDownloadRate, limitUploadRate)
 			if err != nil {
 				fmt.Println(err.Error())
 				os.Exit(1)
 			}
-			destRegistry, destImage, err := ImageNameAndRegistry(args[1])
+			workers, err := concurrencyOptions(cmd, cfg, concurrency)
 			if err != nil {
 				fmt.Println(err.Error())
 				os.Exit(1)
 			}
-			replaceRegistryName(&srcRegistry)
-			replaceRegistryName(&destRegistry)
-			if srcHTTP {
-				addRegistryProtocol(&srcRegistry, false)
-			} else {
-				addRegistryProtocol(&srcRegistry, true)
+			retry, err := retryPolicy(cmd, cfg, retryAttempts)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
 			}
-			if destHTTP {
-				addRegistryProtocol(&destRegistry, false)
-			} else {
-				addRegistryProtocol(&destRegistry, true)
+			if srcPasswordStdin && destPasswordStdin {
+				fmt.Println("Only one of --src-password-stdin and --dest-password-stdin can be used")
+				os.Exit(1)
 			}
-			if len(tagRegexp) > 0 {
-				_, err = regexp.Compile(tagRegexp)
+			srcUsername, srcPassword, err = credentials("src", srcUsername, srcPassword, srcPasswordFile, srcPasswordStdin)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			destUsername, destPassword, err = credentials("dest", destUsername, destPassword, destPasswordFile, destPasswordStdin)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+
+			prom := &tags.TagPush{
+				SrcRegistry:        srcRegistry,
+				SrcImage:           srcRef.Repository,
+				SrcUsername:        srcUsername,
+				SrcPassword:        srcPassword,
+				SrcInsecure:        srcInsecure,
+				SrcCAFile:          srcCAFile,
+				SrcCertFile:        srcCertFile,
+				SrcKeyFile:         srcKeyFile,
+				DestRegistry:       destRegistry,
+				DestImage:          destRef.Repository,
+				DestUsername:       destUsername,
+				DestPassword:       destPassword,
+				DestInsecure:       destInsecure,
+				DestCAFile:         destCAFile,
+				DestCertFile:       destCertFile,
+				DestKeyFile:        destKeyFile,
+				TagRegexp:          tagRegexp,
+				TagExclude:         tagExclude,
+				Semver:             semverRange,
+				Latest:             latest,
+				LatestBy:           latestBy,
+				DryRun:             dryRun,
+				TagTemplate:        tagTemplate,
+				TagRewrite:         tagRewrite,
+				Force:              force,
+				Prune:              prune,
+				PruneMax:           pruneMax,
+				Platforms:          platforms,
+				Transfer:           transfer,
+				Concurrency:        workers,
+				Retry:              retry,
+				InsecureRegistries: insecureRegistries,
+				SrcProxy:           cfg.Registry(srcRef.Endpoint()).Proxy,
+				DestProxy:          cfg.Registry(destRef.Endpoint()).Proxy,
+				SrcMirrors:         cfg.Registry(srcRef.Endpoint()).Mirrors,
+				Output:             output,
+			}
+			setupLogging(debug)
+			_, err = prom.PushTags(commandContext())
+			exit(err)
+
+		},
+	}
+
+	var syncCmd = &cobra.Command{
+		Use:   "sync -f [file]",
+		Short: "Push image tags listed in file",
+		Long:  `Push image tags of all repository pairs listed in JSON or YAML sync file, sharing connections and layers between them`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			if syncFile == "" {
+				fmt.Println("Missing sync file, usage: sync -f [file.json]")
+				os.Exit(1)
+			}
+			cfg, err := config.Load(configFile)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			file, err := config.LoadSync(syncFile)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			transfer, err := transferOptions(chunkSize, limitRate, limitDownloadRate, limitUploadRate)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			workers, err := concurrencyOptions(cmd, cfg, concurrency)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			retry, err := retryPolicy(cmd, cfg, retryAttempts)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			pushes := make([]*tags.TagPush, 0, len(file.Entries))
+			for i, entry := range file.Entries {
+				prom, err := syncPush(cfg, file, entry)
 				if err != nil {
-					fmt.Printf("Image Tag Regexp does not compile. Error: %q \n", err)
+					fmt.Printf("Sync file entry %d: %s\n", i+1, err.Error())
+					os.Exit(1)
+				}
+				prom.Transfer = transfer
+				prom.Concurrency = workers
+				prom.Retry = retry
+				prom.DryRun = dryRun
+				prom.Force = force
+				prom.InsecureRegistries = insecureRegistries
+				//TLS flags apply to entries which do not configure TLS themselves
+				prom.SrcInsecure = prom.SrcInsecure || srcInsecure
+				prom.DestInsecure = prom.DestInsecure || destInsecure
+				if prom.SrcCAFile == "" {
+					prom.SrcCAFile = srcCAFile
 				}
+				if prom.SrcCertFile == "" {
+					prom.SrcCertFile, prom.SrcKeyFile = srcCertFile, srcKeyFile
+				}
+				if prom.DestCAFile == "" {
+					prom.DestCAFile = destCAFile
+				}
+				if prom.DestCertFile == "" {
+					prom.DestCertFile, prom.DestKeyFile = destCertFile, destKeyFile
+				}
+				pushes = append(pushes, prom)
 			}
+			setupLogging(debug)
+			_, err = tags.Sync(commandContext(), pushes, output)
+			exit(err)
 
-			prom := &tags.TagPush{
-				SrcRegistry:  srcRegistry,
-				SrcImage:     srcImage,
-				SrcUsername:  srcUsername,
-				SrcPassword:  srcPassword,
-				SrcInsecure:  srcInsecure,
-				DestRegistry: destRegistry,
-				DestImage:    destImage,
-				DestUsername: destUsername,
-				DestPassword: destPassword,
-				DestInsecure: destInsecure,
-				TagRegexp:    tagRegexp,
-				Debug:        debug,
-			}
-			prom.PushTags()
+		},
+	}
+
+	var mirrorCmd = &cobra.Command{
+		Use:   "mirror [registry/namespace] [registry/namespace]",
+		Short: "Mirror registry repositories",
+		Long:  `Push all tags of all repositories of registry or namespace into another Registry`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			if len(args) < 2 {
+				fmt.Println("Missing command arguments, usage: mirror [registry/namespace] [registry/namespace]")
+				os.Exit(1)
+			}
+			cfg, err := config.Load(configFile)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			srcRef, srcScheme, err := parseNamespace(cfg, args[0])
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			destRef, destScheme, err := parseNamespace(cfg, args[1])
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			if err := validateTagFilters(tagRegexp, tagExclude, semverRange, latest, latestBy); err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			if err := validateTagMapping(tagTemplate, tagRewrite); err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			include, err := compileRegexps(includeRepositories)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			exclude, err := compileRegexps(excludeRepositories)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			var rule *rewrite.Rule
+			if repositoryRewrite != "" {
+				rule, err = rewrite.Parse(repositoryRewrite)
+				if err != nil {
+					fmt.Println(err.Error())
+					os.Exit(1)
+				}
+			}
+			platforms, err := manifest.ParsePlatforms(platform)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+
+			transfer, err := transferOptions(chunkSize, limitRate, limitDownloadRate, limitUploadRate)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			workers, err := concurrencyOptions(cmd, cfg, concurrency)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			retry, err := retryPolicy(cmd, cfg, retryAttempts)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			if srcPasswordStdin && destPasswordStdin {
+				fmt.Println("Only one of --src-password-stdin and --dest-password-stdin can be used")
+				os.Exit(1)
+			}
+			srcUsername, srcPassword, err = credentials("src", srcUsername, srcPassword, srcPasswordFile, srcPasswordStdin)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			destUsername, destPassword, err = credentials("dest", destUsername, destPassword, destPasswordFile, destPasswordStdin)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+
+			mirror := &tags.Mirror{
+				Template: tags.TagPush{
+					SrcRegistry:        registryURL(srcScheme, srcRef.Endpoint(), srcHTTP),
+					SrcUsername:        srcUsername,
+					SrcPassword:        srcPassword,
+					SrcInsecure:        srcInsecure,
+					SrcCAFile:          srcCAFile,
+					SrcCertFile:        srcCertFile,
+					SrcKeyFile:         srcKeyFile,
+					DestRegistry:       registryURL(destScheme, destRef.Endpoint(), destHTTP),
+					DestUsername:       destUsername,
+					DestPassword:       destPassword,
+					DestInsecure:       destInsecure,
+					DestCAFile:         destCAFile,
+					DestCertFile:       destCertFile,
+					DestKeyFile:        destKeyFile,
+					TagRegexp:          tagRegexp,
+					TagExclude:         tagExclude,
+					Semver:             semverRange,
+					Latest:             latest,
+					LatestBy:           latestBy,
+					DryRun:             dryRun,
+					TagTemplate:        tagTemplate,
+					TagRewrite:         tagRewrite,
+					Force:              force,
+					Prune:              prune,
+					PruneMax:           pruneMax,
+					Platforms:          platforms,
+					Transfer:           transfer,
+					Concurrency:        workers,
+					Retry:              retry,
+					InsecureRegistries: insecureRegistries,
+					SrcProxy:           cfg.Registry(srcRef.Endpoint()).Proxy,
+					DestProxy:          cfg.Registry(destRef.Endpoint()).Proxy,
+					SrcMirrors:         cfg.Registry(srcRef.Endpoint()).Mirrors,
+					Output:             output,
+				},
+				SrcNamespace:  srcRef.Repository,
+				DestNamespace: destRef.Repository,
+				Include:       include,
+				Exclude:       exclude,
+				Rewrite:       rule,
+			}
+			setupLogging(debug)
+			_, err = mirror.MirrorRepositories(commandContext())
+			exit(err)
 
 		},
 	}
@@ -165,82 +538,511 @@ func init() {
 	RootCmd.AddCommand(versionCmd)
 	RootCmd.AddCommand(promoteCmd)
 	RootCmd.AddCommand(tagsCmd)
+	RootCmd.AddCommand(syncCmd)
+	RootCmd.AddCommand(mirrorCmd)
 
 	promoteCmd.Flags().StringVar(&srcUsername, "src-username", "", "Source username")
 	promoteCmd.Flags().StringVar(&srcPassword, "src-password", "", "Source password")
 	promoteCmd.Flags().StringVar(&destUsername, "dest-username", "", "Destination username")
 	promoteCmd.Flags().StringVar(&destPassword, "dest-password", "", "Destination password")
+	promoteCmd.Flags().StringVar(&srcPasswordFile, "src-password-file", "", "Read source password from file")
+	promoteCmd.Flags().StringVar(&destPasswordFile, "dest-password-file", "", "Read destination password from file")
+	promoteCmd.Flags().BoolVar(&srcPasswordStdin, "src-password-stdin", false, "Read source password from stdin")
+	promoteCmd.Flags().BoolVar(&destPasswordStdin, "dest-password-stdin", false, "Read destination password from stdin")
 	promoteCmd.Flags().BoolVar(&srcHTTP, "src-http", false, "Use http when connecting to Source Registry")
-	promoteCmd.Flags().BoolVar(&destHTTP, "dest-http", false, "Use http when connecting to Source Registry")
+	promoteCmd.Flags().BoolVar(&destHTTP, "dest-http", false, "Use http when connecting to Destination Registry")
+	promoteCmd.Flags().StringSliceVar(&insecureRegistries, "insecure-registry", nil, "Allow plain HTTP fallback for registry host or CIDR network when HTTPS is not available (can be repeated)")
 	promoteCmd.Flags().BoolVarP(&debug, "debug", "d", false, "Debug")
+	promoteCmd.Flags().StringVar(&configFile, "config", "", "Configuration file (default $PROMOTER_CONFIG or ~/.promoter/config.json)")
 	promoteCmd.Flags().BoolVar(&srcInsecure, "src-insecure", false, "Accept all certificates when connecting to Source Registry")
 	promoteCmd.Flags().BoolVar(&destInsecure, "dest-insecure", false, "Accept all certificates when connecting to Destination Registry")
+	promoteCmd.Flags().StringVar(&srcCAFile, "src-ca-file", "", "Trust certificate authorities from PEM file when connecting to Source Registry")
+	promoteCmd.Flags().StringVar(&srcCertFile, "src-cert", "", "Client certificate used when connecting to Source Registry")
+	promoteCmd.Flags().StringVar(&srcKeyFile, "src-key", "", "Client certificate key used when connecting to Source Registry")
+	promoteCmd.Flags().StringVar(&destCAFile, "dest-ca-file", "", "Trust certificate authorities from PEM file when connecting to Destination Registry")
+	promoteCmd.Flags().StringVar(&destCertFile, "dest-cert", "", "Client certificate used when connecting to Destination Registry")
+	promoteCmd.Flags().StringVar(&destKeyFile, "dest-key", "", "Client certificate key used when connecting to Destination Registry")
+	promoteCmd.Flags().StringVar(&platform, "platform", "", "Promote only specified platforms of multi-architecture image e.g. linux/amd64,linux/arm64")
+	promoteCmd.Flags().StringVar(&chunkSize, "chunk-size", humanize.IBytes(layer.DefaultChunkSize), "Upload layers in chunks of specified size e.g. 16MiB. Layers are uploaded in single request when 0")
+	promoteCmd.Flags().StringVar(&limitRate, "limit-rate", "", "Limit layer transfer rate shared by all concurrent transfers e.g. 50MB/s")
+	promoteCmd.Flags().StringVar(&limitDownloadRate, "limit-download-rate", "", "Limit layer download rate, overrides --limit-rate")
+	promoteCmd.Flags().StringVar(&limitUploadRate, "limit-upload-rate", "", "Limit layer upload rate, overrides --limit-rate")
+	promoteCmd.Flags().IntVar(&concurrency.Manifests, "manifest-concurrency", connection.DefaultManifestWorkers, "Number of manifests fetched or pushed concurrently")
+	promoteCmd.Flags().IntVar(&concurrency.Metadata, "metadata-concurrency", connection.DefaultMetadataWorkers, "Number of concurrent layer metadata requests")
+	promoteCmd.Flags().IntVar(&concurrency.Exists, "exists-concurrency", connection.DefaultExistsWorkers, "Number of concurrent layer existence checks and mounts")
+	promoteCmd.Flags().IntVar(&concurrency.Uploads, "upload-concurrency", connection.DefaultUploadWorkers, "Number of layers transferred concurrently")
+	promoteCmd.Flags().IntVar(&concurrency.MaxPerRegistry, "max-connections-per-registry", 0, "Limit concurrent operations against single registry host shared by all pools, 0 for no limit")
+	promoteCmd.Flags().IntVar(&retryAttempts, "retry-attempts", connection.DefaultRetryAttempts, "Maximum number of attempts of registry request failing with transient error, 1 disables retries")
 	tagsCmd.Flags().StringVar(&srcUsername, "src-username", "", "Source username")
 	tagsCmd.Flags().StringVar(&srcPassword, "src-password", "", "Source password")
 	tagsCmd.Flags().StringVar(&destUsername, "dest-username", "", "Destination username")
 	tagsCmd.Flags().StringVar(&destPassword, "dest-password", "", "Destination password")
+	tagsCmd.Flags().StringVar(&srcPasswordFile, "src-password-file", "", "Read source password from file")
+	tagsCmd.Flags().StringVar(&destPasswordFile, "dest-password-file", "", "Read destination password from file")
+	tagsCmd.Flags().BoolVar(&srcPasswordStdin, "src-password-stdin", false, "Read source password from stdin")
+	tagsCmd.Flags().BoolVar(&destPasswordStdin, "dest-password-stdin", false, "Read destination password from stdin")
 	tagsCmd.Flags().BoolVar(&srcHTTP, "src-http", false, "Use http when connecting to Source Registry")
-	tagsCmd.Flags().BoolVar(&destHTTP, "dest-http", false, "Use http when connecting to Source Registry")
+	tagsCmd.Flags().BoolVar(&destHTTP, "dest-http", false, "Use http when connecting to Destination Registry")
+	tagsCmd.Flags().StringSliceVar(&insecureRegistries, "insecure-registry", nil, "Allow plain HTTP fallback for registry host or CIDR network when HTTPS is not available (can be repeated)")
 
 	tagsCmd.Flags().BoolVarP(&debug, "debug", "d", false, "Debug")
+	tagsCmd.Flags().StringVar(&configFile, "config", "", "Configuration file (default $PROMOTER_CONFIG or ~/.promoter/config.json)")
 
 	tagsCmd.Flags().BoolVar(&srcInsecure, "src-insecure", false, "Accept all certificates when connecting to Source Registry")
 	tagsCmd.Flags().BoolVar(&destInsecure, "dest-insecure", false, "Accept all certificates when connecting to Destination Registry")
+	tagsCmd.Flags().StringVar(&srcCAFile, "src-ca-file", "", "Trust certificate authorities from PEM file when connecting to Source Registry")
+	tagsCmd.Flags().StringVar(&srcCertFile, "src-cert", "", "Client certificate used when connecting to Source Registry")
+	tagsCmd.Flags().StringVar(&srcKeyFile, "src-key", "", "Client certificate key used when connecting to Source Registry")
+	tagsCmd.Flags().StringVar(&destCAFile, "dest-ca-file", "", "Trust certificate authorities from PEM file when connecting to Destination Registry")
+	tagsCmd.Flags().StringVar(&destCertFile, "dest-cert", "", "Client certificate used when connecting to Destination Registry")
+	tagsCmd.Flags().StringVar(&destKeyFile, "dest-key", "", "Client certificate key used when connecting to Destination Registry")
 	tagsCmd.Flags().StringVar(&tagRegexp, "tag-regexp", "", "Filter image tags by specified regexp")
+	tagsCmd.Flags().StringVar(&tagExclude, "tag-exclude", "", "Skip image tags matching specified regexp")
+	tagsCmd.Flags().StringVar(&semverRange, "semver", "", "Promote only tags which are semantic versions within range e.g. \">=1.4 <2.0\"")
+	tagsCmd.Flags().IntVar(&latest, "latest", 0, "Promote only newest N tags passing other filters")
+	tagsCmd.Flags().StringVar(&latestBy, "latest-by", tags.LatestBySemver, "Order tags for --latest by semver or created (image creation time)")
+	tagsCmd.Flags().BoolVar(&dryRun, "dry-run", false, "List tags selected and rejected by tag filters without promoting them")
+	tagsCmd.Flags().BoolVar(&force, "force", false, "Push tags even when destination tag already holds the same manifest")
+	tagsCmd.Flags().BoolVar(&prune, "prune", false, "Delete destination tags which are not among promoted source tags")
+	tagsCmd.Flags().IntVar(&pruneMax, "prune-max", defaultPruneMax, "Refuse pruning when more tags would be deleted, 0 for no limit")
+	tagsCmd.Flags().StringVar(&tagTemplate, "tag-template", "", "Destination tag template e.g. \"{{.Tag}}-prod\"")
+	tagsCmd.Flags().StringVar(&tagRewrite, "tag-rewrite", "", "Rewrite destination tag e.g. 's/^rc-(.*)$/\\1/'")
+	tagsCmd.Flags().StringVar(&platform, "platform", "", "Promote only specified platforms of multi-architecture images e.g. linux/amd64,linux/arm64")
+	tagsCmd.Flags().StringVar(&chunkSize, "chunk-size", humanize.IBytes(layer.DefaultChunkSize), "Upload layers in chunks of specified size e.g. 16MiB. Layers are uploaded in single request when 0")
+	tagsCmd.Flags().StringVar(&limitRate, "limit-rate", "", "Limit layer transfer rate shared by all concurrent transfers e.g. 50MB/s")
+	tagsCmd.Flags().StringVar(&limitDownloadRate, "limit-download-rate", "", "Limit layer download rate, overrides --limit-rate")
+	tagsCmd.Flags().StringVar(&limitUploadRate, "limit-upload-rate", "", "Limit layer upload rate, overrides --limit-rate")
+	tagsCmd.Flags().IntVar(&concurrency.Manifests, "manifest-concurrency", connection.DefaultManifestWorkers, "Number of manifests fetched or pushed concurrently")
+	tagsCmd.Flags().IntVar(&concurrency.Metadata, "metadata-concurrency", connection.DefaultMetadataWorkers, "Number of concurrent layer metadata requests")
+	tagsCmd.Flags().IntVar(&concurrency.Exists, "exists-concurrency", connection.DefaultExistsWorkers, "Number of concurrent layer existence checks and mounts")
+	tagsCmd.Flags().IntVar(&concurrency.Uploads, "upload-concurrency", connection.DefaultUploadWorkers, "Number of layers transferred concurrently")
+	tagsCmd.Flags().IntVar(&concurrency.MaxPerRegistry, "max-connections-per-registry", 0, "Limit concurrent operations against single registry host shared by all pools, 0 for no limit")
+	tagsCmd.Flags().IntVar(&retryAttempts, "retry-attempts", connection.DefaultRetryAttempts, "Maximum number of attempts of registry request failing with transient error, 1 disables retries")
+	syncCmd.Flags().StringVarP(&syncFile, "file", "f", "", "Sync file listing source and destination repositories, YAML when file has .yaml or .yml extension, JSON otherwise")
+	syncCmd.Flags().BoolVar(&dryRun, "dry-run", false, "List tags selected and rejected by tag filters without promoting them")
+	syncCmd.Flags().BoolVar(&force, "force", false, "Push tags even when destination tag already holds the same manifest")
+	syncCmd.Flags().StringSliceVar(&insecureRegistries, "insecure-registry", nil, "Allow plain HTTP fallback for registry host or CIDR network when HTTPS is not available (can be repeated)")
+	syncCmd.Flags().BoolVar(&srcInsecure, "src-insecure", false, "Accept all certificates when connecting to Source Registries of entries")
+	syncCmd.Flags().BoolVar(&destInsecure, "dest-insecure", false, "Accept all certificates when connecting to Destination Registries of entries")
+	syncCmd.Flags().StringVar(&srcCAFile, "src-ca-file", "", "Trust certificate authorities from PEM file when connecting to Source Registries of entries without sourceCAFile")
+	syncCmd.Flags().StringVar(&srcCertFile, "src-cert", "", "Client certificate used when connecting to Source Registries of entries without sourceCert")
+	syncCmd.Flags().StringVar(&srcKeyFile, "src-key", "", "Client certificate key used when connecting to Source Registries of entries without sourceCert")
+	syncCmd.Flags().StringVar(&destCAFile, "dest-ca-file", "", "Trust certificate authorities from PEM file when connecting to Destination Registries of entries without destinationCAFile")
+	syncCmd.Flags().StringVar(&destCertFile, "dest-cert", "", "Client certificate used when connecting to Destination Registries of entries without destinationCert")
+	syncCmd.Flags().StringVar(&destKeyFile, "dest-key", "", "Client certificate key used when connecting to Destination Registries of entries without destinationCert")
+	syncCmd.Flags().BoolVarP(&debug, "debug", "d", false, "Debug")
+	syncCmd.Flags().StringVar(&configFile, "config", "", "Configuration file (default $PROMOTER_CONFIG or ~/.promoter/config.json)")
+	syncCmd.Flags().StringVar(&chunkSize, "chunk-size", humanize.IBytes(layer.DefaultChunkSize), "Upload layers in chunks of specified size e.g. 16MiB. Layers are uploaded in single request when 0")
+	syncCmd.Flags().StringVar(&limitRate, "limit-rate", "", "Limit layer transfer rate shared by all concurrent transfers e.g. 50MB/s")
+	syncCmd.Flags().StringVar(&limitDownloadRate, "limit-download-rate", "", "Limit layer download rate, overrides --limit-rate")
+	syncCmd.Flags().StringVar(&limitUploadRate, "limit-upload-rate", "", "Limit layer upload rate, overrides --limit-rate")
+	syncCmd.Flags().IntVar(&concurrency.Manifests, "manifest-concurrency", connection.DefaultManifestWorkers, "Number of manifests fetched or pushed concurrently")
+	syncCmd.Flags().IntVar(&concurrency.Metadata, "metadata-concurrency", connection.DefaultMetadataWorkers, "Number of concurrent layer metadata requests")
+	syncCmd.Flags().IntVar(&concurrency.Exists, "exists-concurrency", connection.DefaultExistsWorkers, "Number of concurrent layer existence checks and mounts")
+	syncCmd.Flags().IntVar(&concurrency.Uploads, "upload-concurrency", connection.DefaultUploadWorkers, "Number of layers transferred concurrently")
+	syncCmd.Flags().IntVar(&concurrency.MaxPerRegistry, "max-connections-per-registry", 0, "Limit concurrent operations against single registry host shared by all pools, 0 for no limit")
+	syncCmd.Flags().IntVar(&retryAttempts, "retry-attempts", connection.DefaultRetryAttempts, "Maximum number of attempts of registry request failing with transient error, 1 disables retries")
+	mirrorCmd.Flags().StringVar(&srcUsername, "src-username", "", "Source username")
+	mirrorCmd.Flags().StringVar(&srcPassword, "src-password", "", "Source password")
+	mirrorCmd.Flags().StringVar(&destUsername, "dest-username", "", "Destination username")
+	mirrorCmd.Flags().StringVar(&destPassword, "dest-password", "", "Destination password")
+	mirrorCmd.Flags().StringVar(&srcPasswordFile, "src-password-file", "", "Read source password from file")
+	mirrorCmd.Flags().StringVar(&destPasswordFile, "dest-password-file", "", "Read destination password from file")
+	mirrorCmd.Flags().BoolVar(&srcPasswordStdin, "src-password-stdin", false, "Read source password from stdin")
+	mirrorCmd.Flags().BoolVar(&destPasswordStdin, "dest-password-stdin", false, "Read destination password from stdin")
+	mirrorCmd.Flags().BoolVar(&srcHTTP, "src-http", false, "Use http when connecting to Source Registry")
+	mirrorCmd.Flags().BoolVar(&destHTTP, "dest-http", false, "Use http when connecting to Destination Registry")
+	mirrorCmd.Flags().StringSliceVar(&insecureRegistries, "insecure-registry", nil, "Allow plain HTTP fallback for registry host or CIDR network when HTTPS is not available (can be repeated)")
+	mirrorCmd.Flags().BoolVarP(&debug, "debug", "d", false, "Debug")
+	mirrorCmd.Flags().StringVar(&configFile, "config", "", "Configuration file (default $PROMOTER_CONFIG or ~/.promoter/config.json)")
+	mirrorCmd.Flags().BoolVar(&srcInsecure, "src-insecure", false, "Accept all certificates when connecting to Source Registry")
+	mirrorCmd.Flags().BoolVar(&destInsecure, "dest-insecure", false, "Accept all certificates when connecting to Destination Registry")
+	mirrorCmd.Flags().StringVar(&srcCAFile, "src-ca-file", "", "Trust certificate authorities from PEM file when connecting to Source Registry")
+	mirrorCmd.Flags().StringVar(&srcCertFile, "src-cert", "", "Client certificate used when connecting to Source Registry")
+	mirrorCmd.Flags().StringVar(&srcKeyFile, "src-key", "", "Client certificate key used when connecting to Source Registry")
+	mirrorCmd.Flags().StringVar(&destCAFile, "dest-ca-file", "", "Trust certificate authorities from PEM file when connecting to Destination Registry")
+	mirrorCmd.Flags().StringVar(&destCertFile, "dest-cert", "", "Client certificate used when connecting to Destination Registry")
+	mirrorCmd.Flags().StringVar(&destKeyFile, "dest-key", "", "Client certificate key used when connecting to Destination Registry")
+	mirrorCmd.Flags().StringVar(&tagRegexp, "tag-regexp", "", "Filter image tags by specified regexp")
+	mirrorCmd.Flags().StringVar(&tagExclude, "tag-exclude", "", "Skip image tags matching specified regexp")
+	mirrorCmd.Flags().StringVar(&semverRange, "semver", "", "Promote only tags which are semantic versions within range e.g. \">=1.4 <2.0\"")
+	mirrorCmd.Flags().IntVar(&latest, "latest", 0, "Promote only newest N tags passing other filters")
+	mirrorCmd.Flags().StringVar(&latestBy, "latest-by", tags.LatestBySemver, "Order tags for --latest by semver or created (image creation time)")
+	mirrorCmd.Flags().BoolVar(&dryRun, "dry-run", false, "List tags selected and rejected by tag filters without promoting them")
+	mirrorCmd.Flags().BoolVar(&force, "force", false, "Push tags even when destination tag already holds the same manifest")
+	mirrorCmd.Flags().BoolVar(&prune, "prune", false, "Delete destination tags which are not among promoted source tags")
+	mirrorCmd.Flags().IntVar(&pruneMax, "prune-max", defaultPruneMax, "Refuse pruning when more tags would be deleted, 0 for no limit")
+	mirrorCmd.Flags().StringVar(&tagTemplate, "tag-template", "", "Destination tag template e.g. \"{{.Tag}}-prod\"")
+	mirrorCmd.Flags().StringVar(&tagRewrite, "tag-rewrite", "", "Rewrite destination tag e.g. 's/^rc-(.*)$/\\1/'")
+	mirrorCmd.Flags().StringArrayVar(&includeRepositories, "include", nil, "Mirror only repositories matching regexp e.g. ^team/ (can be repeated)")
+	mirrorCmd.Flags().StringArrayVar(&excludeRepositories, "exclude", nil, "Skip repositories matching regexp (can be repeated)")
+	mirrorCmd.Flags().StringVar(&repositoryRewrite, "rewrite", "", "Rewrite repository path relative to source namespace e.g. 's/^legacy-(.*)$/\\1/'")
+	mirrorCmd.Flags().StringVar(&platform, "platform", "", "Promote only specified platforms of multi-architecture images e.g. linux/amd64,linux/arm64")
+	mirrorCmd.Flags().StringVar(&chunkSize, "chunk-size", humanize.IBytes(layer.DefaultChunkSize), "Upload layers in chunks of specified size e.g. 16MiB. Layers are uploaded in single request when 0")
+	mirrorCmd.Flags().StringVar(&limitRate, "limit-rate", "", "Limit layer transfer rate shared by all concurrent transfers e.g. 50MB/s")
+	mirrorCmd.Flags().StringVar(&limitDownloadRate, "limit-download-rate", "", "Limit layer download rate, overrides --limit-rate")
+	mirrorCmd.Flags().StringVar(&limitUploadRate, "limit-upload-rate", "", "Limit layer upload rate, overrides --limit-rate")
+	mirrorCmd.Flags().IntVar(&concurrency.Manifests, "manifest-concurrency", connection.DefaultManifestWorkers, "Number of manifests fetched or pushed concurrently")
+	mirrorCmd.Flags().IntVar(&concurrency.Metadata, "metadata-concurrency", connection.DefaultMetadataWorkers, "Number of concurrent layer metadata requests")
+	mirrorCmd.Flags().IntVar(&concurrency.Exists, "exists-concurrency", connection.DefaultExistsWorkers, "Number of concurrent layer existence checks and mounts")
+	mirrorCmd.Flags().IntVar(&concurrency.Uploads, "upload-concurrency", connection.DefaultUploadWorkers, "Number of layers transferred concurrently")
+	mirrorCmd.Flags().IntVar(&concurrency.MaxPerRegistry, "max-connections-per-registry", 0, "Limit concurrent operations against single registry host shared by all pools, 0 for no limit")
+	mirrorCmd.Flags().IntVar(&retryAttempts, "retry-attempts", connection.DefaultRetryAttempts, "Maximum number of attempts of registry request failing with transient error, 1 disables retries")
 }
 
-//ImageNameAndRegistry returns registry, image from provided fqdn
-func ImageNameAndRegistry(url string) (registry string, image string, err error) {
-	s := strings.Split(url, "/")
-	if len(s) < 3 {
-		return "", "", errors.New("invalid image reference. Image format should be following: [registry/repository/image] e.g. myregistry/repository/centos")
+//syncPush builds tags promotion of sync file entry
+func syncPush(cfg *config.Config, file *config.SyncFile, entry config.SyncEntry) (*tags.TagPush, error) {
+	srcRef, srcScheme, err := parseReference(cfg, entry.Source, true, false)
+	if err != nil {
+		return nil, err
+	}
+	destRef, destScheme, err := parseReference(cfg, entry.Destination, true, true)
+	if err != nil {
+		return nil, err
 	}
-	registry = s[0]
-	image = s[1] + "/" + s[2]
-	return registry, image, nil
+	if err := validateTagFilters(entry.TagRegexp, entry.TagExclude, entry.Semver, entry.Latest, entry.LatestBy); err != nil {
+		return nil, err
+	}
+	if err := validateTagMapping(entry.TagTemplate, entry.TagRewrite); err != nil {
+		return nil, err
+	}
+	platforms, err := manifest.ParsePlatforms(entry.Platform)
+	if err != nil {
+		return nil, err
+	}
+	pruneMax := defaultPruneMax
+	if entry.PruneMax != nil {
+		pruneMax = *entry.PruneMax
+	}
+	var srcUsername, srcPassword, destUsername, destPassword string
+	if entry.SourceCredentials != "" {
+		if srcUsername, srcPassword, err = file.Credentials[entry.SourceCredentials].Resolve(); err != nil {
+			return nil, fmt.Errorf("credentials %s: %w", entry.SourceCredentials, err)
+		}
+	}
+	if entry.DestinationCredentials != "" {
+		if destUsername, destPassword, err = file.Credentials[entry.DestinationCredentials].Resolve(); err != nil {
+			return nil, fmt.Errorf("credentials %s: %w", entry.DestinationCredentials, err)
+		}
+	}
+	return &tags.TagPush{
+		SrcRegistry:  registryURL(srcScheme, srcRef.Endpoint(), false),
+		SrcImage:     srcRef.Repository,
+		SrcUsername:  srcUsername,
+		SrcPassword:  srcPassword,
+		DestRegistry: registryURL(destScheme, destRef.Endpoint(), false),
+		DestImage:    destRef.Repository,
+		DestUsername: destUsername,
+		DestPassword: destPassword,
+		TagRegexp:    entry.TagRegexp,
+		TagExclude:   entry.TagExclude,
+		Semver:       entry.Semver,
+		Latest:       entry.Latest,
+		LatestBy:     entry.LatestBy,
+		TagMapping:   entry.Tags,
+		TagTemplate:  entry.TagTemplate,
+		TagRewrite:   entry.TagRewrite,
+		Prune:        entry.Prune,
+		PruneMax:     pruneMax,
+		Platforms:    platforms,
+		SrcProxy:     cfg.Registry(srcRef.Endpoint()).Proxy,
+		DestProxy:    cfg.Registry(destRef.Endpoint()).Proxy,
+		SrcMirrors:   cfg.Registry(srcRef.Endpoint()).Mirrors,
+		SrcInsecure:  entry.SourceInsecure,
+		SrcCAFile:    entry.SourceCAFile,
+		SrcCertFile:  entry.SourceCert,
+		SrcKeyFile:   entry.SourceKey,
+		DestInsecure: entry.DestinationInsecure,
+		DestCAFile:   entry.DestinationCAFile,
+		DestCertFile: entry.DestinationCert,
+		DestKeyFile:  entry.DestinationKey,
+		Output:       output,
+	}, nil
+}
 
+//parseReference parses image reference, which can be prefixed with registry scheme e.g. https://registry.corp/ns/app:1.0
+//or start with registry alias defined in configuration file. Destination references whose first component earlier releases
+//treated as registry host are rejected, source references only print warning
+func parseReference(cfg *config.Config, s string, repositoryOnly bool, destination bool) (*reference.Reference, string, error) {
+	s = cfg.ExpandAlias(s)
+	scheme := ""
+	if i := strings.Index(s, "://"); i >= 0 {
+		scheme = strings.ToLower(s[:i])
+		if scheme != "http" && scheme != "https" {
+			return nil, "", errors.New("invalid image reference " + s + ". Only http and https registry schemes are supported")
+		}
+		s = s[i+3:]
+	}
+	var ref *reference.Reference
+	var err error
+	if repositoryOnly {
+		ref, err = reference.ParseRepository(s)
+	} else {
+		ref, err = reference.Parse(s)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if registry := reference.LegacyRegistry(s); registry != "" {
+		//Promoting to Docker Hub instead of private registry can not be undone, so destinations have to be explicit
+		if destination {
+			return nil, "", errors.New("destination image reference " + s + " refers to Docker Hub image " + ref.String() + ", earlier promoter releases treated " + registry + " as registry host. Use docker.io/" + s + " to promote to Docker Hub or registry host with domain or port e.g. " + registry + ".corp or " + registry + ":5000")
+		}
+		fmt.Fprintf(output, "Warning: %s is no longer treated as registry host, %s refers to Docker Hub image %s \n", registry, s, ref.String())
+	}
+	return ref, scheme, nil
 }
 
-//ImageNameAndRegistryAndTag returns registry, image and tag from provided fqdn
-func ImageNameAndRegistryAndTag(src string) (registry string, image string, tag string, err error) {
-	s := strings.Split(src, "/")
-	if len(s) < 3 {
-		return "", "", "", errors.New("invalid image reference. Image format should be following: [registry/repository/image] e.g. hub.docker.io/library/centos")
+//parseNamespace parses registry namespace, which can be prefixed with registry scheme or start with registry alias
+func parseNamespace(cfg *config.Config, s string) (*reference.Reference, string, error) {
+	if registry, ok := cfg.Aliases[strings.TrimSuffix(s, "/")]; ok {
+		s = registry
+	}
+	s = cfg.ExpandAlias(s)
+	scheme := ""
+	if i := strings.Index(s, "://"); i >= 0 {
+		scheme = strings.ToLower(s[:i])
+		if scheme != "http" && scheme != "https" {
+			return nil, "", errors.New("invalid namespace " + s + ". Only http and https registry schemes are supported")
+		}
+		s = s[i+3:]
+	}
+	ref, err := reference.ParseNamespace(s)
+	return ref, scheme, err
+}
+
+//validateTagFilters checks tag filters before registries are contacted
+func validateTagFilters(tagRegexp string, tagExclude string, semverRange string, latest int, latestBy string) error {
+	if tagRegexp != "" {
+		if _, err := regexp.Compile(tagRegexp); err != nil {
+			return fmt.Errorf("image tag regexp does not compile: %w", err)
+		}
+	}
+	if tagExclude != "" {
+		if _, err := regexp.Compile(tagExclude); err != nil {
+			return fmt.Errorf("image tag exclude regexp does not compile: %w", err)
+		}
+	}
+	if semverRange != "" {
+		if _, err := semver.ParseConstraint(semverRange); err != nil {
+			return err
+		}
+	}
+	if latest < 0 {
+		return errors.New("number of latest tags can not be negative")
+	}
+	if latestBy != "" && latestBy != tags.LatestBySemver && latestBy != tags.LatestByCreated {
+		return errors.New("tags can be ordered by " + tags.LatestBySemver + " or " + tags.LatestByCreated + ", not " + latestBy)
+	}
+	return nil
+}
+
+//validateTagMapping checks tag template and rewrite rule
+func validateTagMapping(tagTemplate string, tagRewrite string) error {
+	if tagTemplate != "" {
+		if _, err := template.New("tag").Parse(tagTemplate); err != nil {
+			return fmt.Errorf("invalid tag template: %w", err)
+		}
+	}
+	if tagRewrite != "" {
+		if _, err := rewrite.Parse(tagRewrite); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+//compileRegexps compiles repository filters
+func compileRegexps(expressions []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(expressions))
+	for _, expression := range expressions {
+		r, err := regexp.Compile(expression)
+		if err != nil {
+			return nil, fmt.Errorf("repository regexp %s does not compile: %w", expression, err)
+		}
+		compiled = append(compiled, r)
 	}
-	registry = s[0]
+	return compiled, nil
+}
 
-	image = strings.Join(s[1:], "/")
+//registryURL returns registry URL with explicit scheme, plain HTTP when forced by --src-http or --dest-http,
+//otherwise registry host only, so the scheme is negotiated when connecting
+func registryURL(scheme string, endpoint string, forceHTTP bool) string {
+	if scheme != "" {
+		return scheme + "://" + endpoint
+	}
+	if forceHTTP {
+		return "http://" + endpoint
+	}
+	return endpoint
+}
 
-	imageAndTag := strings.Split(image, ":")
-	//Image name and tag specified
-	if len(imageAndTag) > 1 {
-		image = imageAndTag[0]
-		tag = imageAndTag[1]
-	} else {
-		//No tag specified
-		image = s[1] + "/" + s[2]
-		tag = "latest"
+//transferOptions parses layer transfer flags
+func transferOptions(chunkSize string, limitRate string, downloadRate string, uploadRate string) (layer.Options, error) {
+	size, err := humanize.ParseBytes(chunkSize)
+	if err != nil {
+		return layer.Options{}, errors.New("invalid chunk size " + chunkSize + ". Size should be specified in bytes e.g. 16MiB")
+	}
+	//Direction specific limits take precedence over --limit-rate
+	if downloadRate == "" {
+		downloadRate = limitRate
+	}
+	if uploadRate == "" {
+		uploadRate = limitRate
 	}
-	return registry, image, tag, nil
+	download, err := parseRate(downloadRate)
+	if err != nil {
+		return layer.Options{}, err
+	}
+	upload, err := parseRate(uploadRate)
+	if err != nil {
+		return layer.Options{}, err
+	}
+	return layer.Options{
+		ChunkSize:     int64(size),
+		DownloadLimit: layer.NewRateLimiter(download),
+		UploadLimit:   layer.NewRateLimiter(upload),
+	}, nil
 }
 
-//Adds HTTP or HTTPS suffix if it's missing
-func addRegistryProtocol(registry *string, secure bool) {
-	if !strings.HasPrefix(*registry, "http") || !strings.HasPrefix(*registry, "https") {
-		if secure {
-			*registry = "https://" + *registry
-		} else {
-			*registry = "http://" + *registry
+//parseRate parses transfer rate in bytes per second e.g. 50MB/s. Empty rate means no limit
+func parseRate(rate string) (int64, error) {
+	if rate == "" {
+		return 0, nil
+	}
+	bytes, err := humanize.ParseBytes(strings.TrimSuffix(strings.TrimSuffix(strings.TrimSpace(rate), "/s"), "ps"))
+	if err != nil {
+		return 0, errors.New("invalid transfer rate " + rate + ". Rate should be specified in bytes per second e.g. 50MB/s")
+	}
+	return int64(bytes), nil
+}
+
+//concurrencyOptions merges concurrency flags with configuration file. Flags which were not specified fall back to configuration file
+func concurrencyOptions(cmd *cobra.Command, cfg *config.Config, c connection.Concurrency) (connection.Concurrency, error) {
+	settings := []struct {
+		flag       string
+		value      *int
+		configured int
+		min        int
+	}{
+		{"manifest-concurrency", &c.Manifests, cfg.Concurrency.Manifests, 1},
+		{"metadata-concurrency", &c.Metadata, cfg.Concurrency.Metadata, 1},
+		{"exists-concurrency", &c.Exists, cfg.Concurrency.Exists, 1},
+		{"upload-concurrency", &c.Uploads, cfg.Concurrency.Uploads, 1},
+		{"max-connections-per-registry", &c.MaxPerRegistry, cfg.Concurrency.MaxConnectionsPerRegistry, 0},
+	}
+	for _, setting := range settings {
+		if !cmd.Flags().Changed(setting.flag) && setting.configured != 0 {
+			*setting.value = setting.configured
+		}
+		if *setting.value < setting.min {
+			return c, fmt.Errorf("--%s must be at least %d", setting.flag, setting.min)
 		}
 	}
+	c.RegistryMax = make(map[string]int)
+	for host, r := range cfg.Registries {
+		if r.MaxConnections < 0 {
+			return c, errors.New("maxConnections of registry " + host + " must not be negative")
+		}
+		if r.MaxConnections > 0 {
+			c.RegistryMax[host] = r.MaxConnections
+		}
+	}
+	return c, nil
 }
 
-//Replaces some hardcoded registry names
-func replaceRegistryName(registry *string) {
-	if strings.Contains(*registry, "docker.io") {
-		//*registry = "index.docker.io"
-		*registry = "registry-1.docker.io"
+//retryPolicy builds retry policy of registry requests. Attempts fall back to configuration file when flag was not specified
+func retryPolicy(cmd *cobra.Command, cfg *config.Config, attempts int) (connection.RetryPolicy, error) {
+	if !cmd.Flags().Changed("retry-attempts") && cfg.Retry.Attempts != 0 {
+		attempts = cfg.Retry.Attempts
+	}
+	if attempts < 1 {
+		return connection.RetryPolicy{}, errors.New("--retry-attempts must be at least 1")
+	}
+	return connection.RetryPolicy{Attempts: attempts}, nil
+}
 
+//credentials resolves registry username and password. Password is taken from flag, file or stdin, whichever is specified,
+//otherwise from PROMOTER_SRC_PASSWORD or PROMOTER_DEST_PASSWORD environment variable. Username falls back to PROMOTER_SRC_USERNAME or PROMOTER_DEST_USERNAME
+func credentials(prefix string, username string, password string, passwordFile string, passwordStdin bool) (string, string, error) {
+	env := "PROMOTER_" + strings.ToUpper(prefix) + "_"
+	if username == "" {
+		username = os.Getenv(env + "USERNAME")
+	}
+	sources := 0
+	for _, set := range []bool{password != "", passwordFile != "", passwordStdin} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return "", "", errors.New("only one of --" + prefix + "-password, --" + prefix + "-password-file and --" + prefix + "-password-stdin can be used")
+	}
+	switch {
+	case passwordFile != "":
+		data, err := ioutil.ReadFile(passwordFile)
+		if err != nil {
+			return "", "", fmt.Errorf("cannot read %s password file: %w", prefix, err)
+		}
+		password = strings.TrimRight(string(data), "\r\n")
+	case passwordStdin:
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return "", "", fmt.Errorf("cannot read %s password from stdin: %w", prefix, err)
+		}
+		password = strings.TrimRight(string(data), "\r\n")
+	case password == "":
+		password = os.Getenv(env + "PASSWORD")
+	}
+	return username, password, nil
+}
+
+//Registry client logs requests using standard logger, which is enabled only in debug mode
+func setupLogging(debug bool) {
+	if !debug {
+		log.SetOutput(ioutil.Discard)
+		return
+	}
+	log.Printf("promoter args=%s", strings.Join(redactArgs(os.Args[1:]), " "))
+}
+
+//secretFlags lists flags whose values must not be printed
+var secretFlags = []string{"--src-password", "--dest-password"}
+
+//redactArgs replaces secret flag values in command line arguments
+func redactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	copy(redacted, args)
+	for i, arg := range redacted {
+		for _, flag := range secretFlags {
+			if arg == flag && i+1 < len(redacted) {
+				redacted[i+1] = connection.Redacted
+			} else if strings.HasPrefix(arg, flag+"=") {
+				redacted[i] = flag + "=" + connection.Redacted
+			}
+		}
+	}
+	return redacted
+}
+
+//commandContext returns context which is cancelled when user interrupts the command
+func commandContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		fmt.Println("Interrupted, cancelling...")
+		cancel()
+	}()
+	return ctx
+}
+
+//exit terminates command with exit code based on promotion outcome
+func exit(err error) {
+	if err != nil {
+		fmt.Println("Error: " + connection.Redact(err.Error()))
+		os.Exit(1)
 	}
+	os.Exit(0)
 }
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/vbaksa/promoter/config"
+)
+
+func TestParseReferenceLegacyRegistry(t *testing.T) {
+	tests := []struct {
+		input       string
+		destination bool
+		registry    string
+		//error is expected error substring, empty when reference is accepted
+		error string
+		//warning is expected warning substring, empty when nothing is printed
+		warning string
+	}{
+		{"myregistry/team/app", true, "", "earlier promoter releases treated myregistry as registry host", ""},
+		{"myregistry/team/app:1.0", true, "", "Use docker.io/myregistry/team/app:1.0", ""},
+		{"myregistry/team/app", false, "docker.io", "", "myregistry is no longer treated as registry host"},
+		{"docker.io/myregistry/team/app", true, "docker.io", "", ""},
+		{"myregistry.corp/team/app", true, "myregistry.corp", "", ""},
+		{"myregistry:5000/team/app", true, "myregistry:5000", "", ""},
+		{"localhost/team/app", true, "localhost", "", ""},
+		{"myuser/app", true, "docker.io", "", ""},
+		{"corp/team/app", true, "registry.corp", "", ""},
+	}
+	cfg := &config.Config{Aliases: map[string]string{"corp": "registry.corp"}}
+	defer func(w io.Writer) { output = w }(output)
+	for _, test := range tests {
+		var out bytes.Buffer
+		output = &out
+		ref, _, err := parseReference(cfg, test.input, false, test.destination)
+		if test.error != "" {
+			if err == nil || !strings.Contains(err.Error(), test.error) {
+				t.Errorf("%s: error %v, expected it to contain %q", test.input, err, test.error)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.input, err)
+			continue
+		}
+		if ref.Registry != test.registry {
+			t.Errorf("%s: registry %q, expected %q", test.input, ref.Registry, test.registry)
+		}
+		if test.warning == "" && out.Len() > 0 || !strings.Contains(out.String(), test.warning) {
+			t.Errorf("%s: printed %q, expected %q", test.input, out.String(), test.warning)
+		}
+	}
+}
+
+func TestSyncPushTLS(t *testing.T) {
+	entry := config.SyncEntry{
+		Source:            "registry.corp/team/app",
+		Destination:       "backup.corp/team/app",
+		SourceInsecure:    true,
+		DestinationCAFile: "/etc/corp/ca.pem",
+		DestinationCert:   "/etc/corp/ci.pem",
+		DestinationKey:    "/etc/corp/ci.key",
+	}
+	prom, err := syncPush(&config.Config{}, &config.SyncFile{}, entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !prom.SrcInsecure || prom.SrcCAFile != "" || prom.DestInsecure || prom.DestCAFile != entry.DestinationCAFile ||
+		prom.DestCertFile != entry.DestinationCert || prom.DestKeyFile != entry.DestinationKey {
+		t.Errorf("entry %+v promoted with TLS %v %q %v %q %q %q", entry, prom.SrcInsecure, prom.SrcCAFile, prom.DestInsecure, prom.DestCAFile, prom.DestCertFile, prom.DestKeyFile)
+	}
+}
+
+func TestValidateTagFilters(t *testing.T) {
+	tests := []struct {
+		tagRegexp  string
+		tagExclude string
+		semver     string
+		latest     int
+		latestBy   string
+		//error is expected error substring, empty when filters are valid
+		error string
+	}{
+		{`^1\.`, `-rc$`, ">=1.2", 3, "semver", ""},
+		{"", "", "", 0, "", ""},
+		{"(", "", "", 0, "", "image tag regexp does not compile"},
+		{"", "(", "", 0, "", "image tag exclude regexp does not compile"},
+		{"", "", "", -1, "", "can not be negative"},
+		{"", "", "", 1, "date", "not date"},
+	}
+	for _, test := range tests {
+		err := validateTagFilters(test.tagRegexp, test.tagExclude, test.semver, test.latest, test.latestBy)
+		if test.error == "" && err != nil || test.error != "" && (err == nil || !strings.Contains(err.Error(), test.error)) {
+			t.Errorf("%+v: error %v, expected %q", test, err, test.error)
+		}
+	}
+}
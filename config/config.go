@@ -0,0 +1,118 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vbaksa/promoter/reference"
+)
+
+//EnvConfig overrides default configuration file location
+const EnvConfig = "PROMOTER_CONFIG"
+
+//Config is promoter configuration file shared by push and tags commands
+type Config struct {
+	//Aliases map short registry names used in image references to registry hosts e.g. corp -> registry.corp.internal:5000
+	Aliases map[string]string `json:"aliases"`
+	//Registries holds registry specific settings keyed by registry host e.g. registry.corp:5000
+	Registries map[string]Registry `json:"registries"`
+	//Concurrency sets number of concurrent registry operations. Command line flags take precedence
+	Concurrency Concurrency `json:"concurrency"`
+	//Retry configures retries of registry requests failing with transient errors. Command line flags take precedence
+	Retry Retry `json:"retry"`
+}
+
+//Retry holds retry policy of registry requests
+type Retry struct {
+	//Attempts is maximum number of attempts of single request, 1 disables retries
+	Attempts int `json:"attempts,omitempty"`
+}
+
+//Concurrency holds number of workers of promotion pools. Zero values use defaults
+type Concurrency struct {
+	//Manifests is number of manifests fetched or pushed concurrently
+	Manifests int `json:"manifests,omitempty"`
+	//Metadata is number of concurrent layer metadata requests
+	Metadata int `json:"metadata,omitempty"`
+	//Exists is number of concurrent layer existence checks and mounts
+	Exists int `json:"exists,omitempty"`
+	//Uploads is number of layers transferred concurrently
+	Uploads int `json:"uploads,omitempty"`
+	//MaxConnectionsPerRegistry caps concurrent operations against single registry host. No limit when zero
+	MaxConnectionsPerRegistry int `json:"maxConnectionsPerRegistry,omitempty"`
+}
+
+//Registry holds registry specific settings
+type Registry struct {
+	//Proxy overrides proxy taken from HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment variables.
+	//Supported values are http://, https:// and socks5:// URLs with optional user:password@ and "direct" for no proxy
+	Proxy string `json:"proxy,omitempty"`
+	//Mirrors lists pull mirrors tried before the registry when it is promotion source, e.g. mirror.corp for docker.io
+	Mirrors []string `json:"mirrors,omitempty"`
+	//MaxConnections overrides concurrency.maxConnectionsPerRegistry for the registry e.g. to stay polite to docker.io
+	MaxConnections int `json:"maxConnections,omitempty"`
+}
+
+//DefaultPath returns configuration file location: PROMOTER_CONFIG or ~/.promoter/config.json
+func DefaultPath() string {
+	if path := os.Getenv(EnvConfig); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".promoter", "config.json")
+}
+
+//Load reads configuration file. When path is empty, default location is used and missing file results in empty configuration
+func Load(path string) (*Config, error) {
+	explicit := path != ""
+	if !explicit {
+		path = DefaultPath()
+	}
+	c := &Config{}
+	if path == "" {
+		return c, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read configuration file: %w", err)
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("cannot parse configuration file %s: %w", path, err)
+	}
+	return c, nil
+}
+
+//Registry returns settings of registry host. Docker Hub settings can be keyed by any of its names e.g. docker.io
+func (c *Config) Registry(host string) Registry {
+	if r, ok := c.Registries[host]; ok {
+		return r
+	}
+	for key, r := range c.Registries {
+		if strings.EqualFold(key, host) || (reference.IsDockerHub(key) && reference.IsDockerHub(host)) {
+			return r
+		}
+	}
+	return Registry{}
+}
+
+//ExpandAlias replaces registry alias at the beginning of image reference e.g. corp/team/app:1.0 becomes registry.corp.internal:5000/team/app:1.0
+func (c *Config) ExpandAlias(ref string) string {
+	s := strings.SplitN(ref, "/", 2)
+	if len(s) != 2 {
+		return ref
+	}
+	if registry, ok := c.Aliases[s[0]]; ok {
+		return strings.TrimSuffix(registry, "/") + "/" + s[1]
+	}
+	return ref
+}
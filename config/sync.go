@@ -0,0 +1,175 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+//SyncFile lists promotions run by sync command. File is JSON document, or YAML document when file has .yaml or .yml extension.
+//Both formats use the same field names
+type SyncFile struct {
+	//Credentials are named credentials referenced by entries. Secrets are read from environment variables or files, never from the sync file
+	Credentials map[string]Credentials `json:"credentials"`
+	Entries     []SyncEntry            `json:"entries"`
+}
+
+//Credentials reference registry username and password
+type Credentials struct {
+	Username string `json:"username,omitempty"`
+	//UsernameEnv names environment variable holding username
+	UsernameEnv string `json:"usernameEnv,omitempty"`
+	//PasswordEnv names environment variable holding password
+	PasswordEnv string `json:"passwordEnv,omitempty"`
+	//PasswordFile is path of file holding password
+	PasswordFile string `json:"passwordFile,omitempty"`
+}
+
+//SyncEntry describes promotion of repository tags
+type SyncEntry struct {
+	//Source and Destination are repositories e.g. docker.io/library/nginx, optionally prefixed with scheme or registry alias
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	//SourceCredentials and DestinationCredentials name credentials. Docker config credentials are used when empty
+	SourceCredentials      string `json:"sourceCredentials,omitempty"`
+	DestinationCredentials string `json:"destinationCredentials,omitempty"`
+	//SourceInsecure, SourceCAFile, SourceCert and SourceKey configure source registry TLS, and Destination fields destination
+	//registry TLS. Command line flags apply to entries which do not set them
+	SourceInsecure      bool   `json:"sourceInsecure,omitempty"`
+	SourceCAFile        string `json:"sourceCAFile,omitempty"`
+	SourceCert          string `json:"sourceCert,omitempty"`
+	SourceKey           string `json:"sourceKey,omitempty"`
+	DestinationInsecure bool   `json:"destinationInsecure,omitempty"`
+	DestinationCAFile   string `json:"destinationCAFile,omitempty"`
+	DestinationCert     string `json:"destinationCert,omitempty"`
+	DestinationKey      string `json:"destinationKey,omitempty"`
+	//TagRegexp, TagExclude, Semver, Latest and LatestBy filter promoted tags, see tags.TagPush
+	TagRegexp  string `json:"tagRegexp,omitempty"`
+	TagExclude string `json:"tagExclude,omitempty"`
+	Semver     string `json:"semver,omitempty"`
+	Latest     int    `json:"latest,omitempty"`
+	LatestBy   string `json:"latestBy,omitempty"`
+	//Tags maps source tags to destination tags. Other tags are renamed by TagRewrite and TagTemplate or keep their name
+	Tags        map[string]string `json:"tags,omitempty"`
+	TagRewrite  string            `json:"tagRewrite,omitempty"`
+	TagTemplate string            `json:"tagTemplate,omitempty"`
+	//Prune deletes destination tags which are not among promoted tags, at most PruneMax tags (no limit when zero).
+	//Default limit of prune command flag applies when PruneMax is not specified
+	Prune    bool `json:"prune,omitempty"`
+	PruneMax *int `json:"pruneMax,omitempty"`
+	//Platform limits promotion of multi-architecture images e.g. linux/amd64,linux/arm64
+	Platform string `json:"platform,omitempty"`
+}
+
+//LoadSync reads and validates sync file
+func LoadSync(path string) (*SyncFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read sync file: %w", err)
+	}
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		if data, err = yamlToJSON(data); err != nil {
+			return nil, fmt.Errorf("cannot parse sync file %s: %w", path, err)
+		}
+	}
+	f := &SyncFile{}
+	//Unknown fields are rejected, so misspelled settings are not silently ignored
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(f); err != nil {
+		return nil, fmt.Errorf("cannot parse sync file %s: %w", path, err)
+	}
+	if len(f.Entries) == 0 {
+		return nil, errors.New("sync file " + path + " does not contain any entries")
+	}
+	for i, entry := range f.Entries {
+		if entry.Source == "" || entry.Destination == "" {
+			return nil, fmt.Errorf("sync file entry %d: both source and destination have to be specified", i+1)
+		}
+		if (entry.SourceCert == "") != (entry.SourceKey == "") || (entry.DestinationCert == "") != (entry.DestinationKey == "") {
+			return nil, fmt.Errorf("sync file entry %d: client certificate and key have to be specified together", i+1)
+		}
+		for _, name := range []string{entry.SourceCredentials, entry.DestinationCredentials} {
+			if _, ok := f.Credentials[name]; name != "" && !ok {
+				return nil, fmt.Errorf("sync file entry %d: unknown credentials %q", i+1, name)
+			}
+		}
+	}
+	return f, nil
+}
+
+//yamlToJSON converts YAML document to JSON, so YAML sync file is decoded by the same rules as JSON one
+func yamlToJSON(data []byte) ([]byte, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	doc, err := jsonValue(doc)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+//jsonValue replaces YAML mappings with JSON objects. Keys have to be strings, because YAML reads unquoted keys such as 1.0
+//as numbers and their original spelling is lost
+func jsonValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			k, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("key %v is not a string, quote it e.g. \"1.0\": stable", key)
+			}
+			value, err := jsonValue(value)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = value
+		}
+		return m, nil
+	case []interface{}:
+		for i, value := range v {
+			value, err := jsonValue(value)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = value
+		}
+		return v, nil
+	}
+	return v, nil
+}
+
+//Resolve returns referenced username and password
+func (c Credentials) Resolve() (string, string, error) {
+	username := c.Username
+	if c.UsernameEnv != "" {
+		username = os.Getenv(c.UsernameEnv)
+	}
+	password := ""
+	switch {
+	case c.PasswordEnv != "" && c.PasswordFile != "":
+		return "", "", errors.New("only one of passwordEnv and passwordFile can be used")
+	case c.PasswordEnv != "":
+		password = os.Getenv(c.PasswordEnv)
+		if password == "" {
+			return "", "", errors.New("environment variable " + c.PasswordEnv + " is not set")
+		}
+	case c.PasswordFile != "":
+		data, err := ioutil.ReadFile(c.PasswordFile)
+		if err != nil {
+			return "", "", fmt.Errorf("cannot read password file: %w", err)
+		}
+		password = strings.TrimRight(string(data), "\r\n")
+	}
+	return username, password, nil
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "promoter-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	json := `{"credentials": {"corp": {"username": "ci", "passwordEnv": "CORP_PASSWORD"}},
+"entries": [{"source": "docker.io/library/nginx", "destination": "corp/nginx", "tags": {"1.0": "stable"}, "pruneMax": 0, "destinationCredentials": "corp"}]}`
+	yaml := `credentials:
+  corp: {username: ci, passwordEnv: CORP_PASSWORD}
+entries:
+  - source: docker.io/library/nginx
+    destination: corp/nginx
+    tags: {"1.0": stable}
+    pruneMax: 0
+    destinationCredentials: corp
+`
+	tests := []struct {
+		name string
+		data string
+		//error is expected error substring, empty when file is valid
+		error string
+	}{
+		{"sync.json", json, ""},
+		{"sync.yaml", yaml, ""},
+		{"sync.YML", yaml, ""},
+		{"sync.conf", json, ""},
+		{"yaml.json", yaml, "cannot parse sync file"},
+		{"unknown.yaml", yaml + "    tagRegex: ^1\\.\n", `unknown field "tagRegex"`},
+		{"unquoted.yaml", strings.Replace(yaml, `"1.0"`, "1.0", 1), "key 1 is not a string"},
+		{"invalid.yaml", "entries: [", "cannot parse sync file"},
+		{"empty.yaml", "entries: []\n", "does not contain any entries"},
+		{"tls.yaml", yaml + "    destinationCAFile: /etc/corp/ca.pem\n    destinationCert: /etc/corp/ci.pem\n    destinationKey: /etc/corp/ci.key\n    sourceInsecure: true\n", ""},
+		{"certificate.yaml", yaml + "    sourceCert: /etc/corp/ci.pem\n", "client certificate and key have to be specified together"},
+		{"key.json", strings.Replace(json, `"pruneMax"`, `"destinationKey": "/etc/corp/ci.key", "pruneMax"`, 1), "client certificate and key have to be specified together"},
+		{"credentials.yaml", strings.Replace(yaml, "destinationCredentials: corp", "destinationCredentials: hub", 1), `unknown credentials "hub"`},
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, test.name)
+		if err := ioutil.WriteFile(path, []byte(test.data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		f, err := LoadSync(path)
+		if test.error != "" {
+			if err == nil || !strings.Contains(err.Error(), test.error) {
+				t.Errorf("%s: error %v, expected it to contain %q", test.name, err, test.error)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		entry := f.Entries[0]
+		if len(f.Entries) != 1 || entry.Source != "docker.io/library/nginx" || entry.Tags["1.0"] != "stable" || entry.PruneMax == nil || *entry.PruneMax != 0 ||
+			f.Credentials[entry.DestinationCredentials].PasswordEnv != "CORP_PASSWORD" {
+			t.Errorf("%s: loaded %+v, expected single entry", test.name, f)
+		}
+	}
+}
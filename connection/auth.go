@@ -0,0 +1,400 @@
+package connection
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//clientID identifies promoter when tokens are requested from authorization service
+	clientID = "promoter"
+	//defaultTokenLifetime is used when authorization service does not report token expiry
+	defaultTokenLifetime = 60 * time.Second
+	//tokenRefreshMargin is time before expiry when token is refreshed, so it does not expire during the request
+	tokenRefreshMargin = 30 * time.Second
+)
+
+var (
+	challengeParamRegexp = regexp.MustCompile(`(\w+)="([^"]*)"`)
+	repositoryPathRegexp = regexp.MustCompile(`^/v2/(.+)/(?:manifests|blobs|tags)/`)
+)
+
+//authTransport authenticates registry requests. Basic credentials are sent to the registry itself,
+//bearer tokens are requested from authorization service and cached per realm, service and scope
+type authTransport struct {
+	transport   http.RoundTripper
+	url         string
+	credentials Credentials
+
+	mu sync.Mutex
+	//service is authorization service of the registry, known once registry asked for bearer token
+	service *challenge
+	tokens  map[string]*cachedToken
+	//fetches are token requests in flight by challenge key
+	fetches map[string]*tokenFetch
+	//refreshToken is the latest OAuth2 refresh token, initially identity token from docker login
+	refreshToken string
+	//refreshMu serializes refresh token grants, because authorization service may rotate refresh token
+	refreshMu sync.Mutex
+}
+
+//challenge holds bearer authorization challenge parameters
+type challenge struct {
+	realm   string
+	service string
+	scope   string
+}
+
+type cachedToken struct {
+	token   string
+	expires time.Time
+}
+
+//tokenFetch is token request in flight, shared by concurrent requests of the same challenge
+type tokenFetch struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+type tokenResponse struct {
+	Token        string    `json:"token"`
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresIn    int       `json:"expires_in"`
+	IssuedAt     time.Time `json:"issued_at"`
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if strings.HasPrefix(req.URL.String(), t.url) && t.credentials.Username != "" && t.credentials.IdentityToken == "" {
+		req.SetBasicAuth(t.credentials.Username, t.credentials.Password)
+	}
+	//Token for the request scope is reused (or refreshed ahead of expiry) when registry is known to use bearer tokens
+	requested := t.requestChallenge(req)
+	sentToken := ""
+	if requested != nil {
+		token, err := t.token(req.Context(), requested)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		sentToken = token
+	}
+	//Streamed request body can not be sent twice, so authorization challenge of request host is obtained beforehand
+	streamed := req.Body != nil && req.GetBody == nil
+	if sentToken == "" && streamed && t.usesBearer() {
+		c, err := t.hostChallenge(req)
+		if err != nil {
+			return nil, err
+		}
+		if c != nil {
+			token, err := t.token(req.Context(), c)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+			sentToken = token
+		}
+	}
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	c := bearerChallenge(resp)
+	if c == nil || streamed {
+		return resp, nil
+	}
+	resp.Body.Close()
+	t.mu.Lock()
+	t.service = &challenge{realm: c.realm, service: c.service}
+	t.mu.Unlock()
+	if mount := mountScope(req); mount != "" {
+		c.scope = c.scope + " " + mount
+	}
+	//Token was refused, e.g. revoked, so a new one is requested
+	t.forget(c, sentToken)
+	token, err := t.token(req.Context(), c)
+	if err != nil {
+		return nil, err
+	}
+	//Registry scope naming can differ from derived one, so token is reused for the derived scope as well
+	if requested != nil && requested.key() != c.key() {
+		t.alias(requested, c)
+	}
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	retry.Header.Set("Authorization", "Bearer "+token)
+	return t.transport.RoundTrip(retry)
+}
+
+//usesBearer reports whether registry asked for bearer token before
+func (t *authTransport) usesBearer() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.service != nil
+}
+
+//requestChallenge derives token challenge of registry API request from authorization service of the registry
+func (t *authTransport) requestChallenge(req *http.Request) *challenge {
+	t.mu.Lock()
+	service := t.service
+	t.mu.Unlock()
+	if service == nil || !strings.HasPrefix(req.URL.String(), t.url) {
+		return nil
+	}
+	scope := requestScope(req)
+	if scope == "" {
+		return nil
+	}
+	return &challenge{realm: service.realm, service: service.service, scope: scope}
+}
+
+//hostChallenge asks /v2/ endpoint of request host for bearer challenge, scoped to the request. Request itself is not sent,
+//because its body-less copy can have side effects, e.g. empty PUT completes blob upload. Nil is returned when host does not use bearer tokens
+func (t *authTransport) hostChallenge(req *http.Request) (*challenge, error) {
+	ping, err := http.NewRequest("GET", req.URL.Scheme+"://"+req.URL.Host+"/v2/", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.transport.RoundTrip(ping.WithContext(req.Context()))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	c := bearerChallenge(resp)
+	if c == nil {
+		return nil, nil
+	}
+	if scope := requestScope(req); scope != "" {
+		c.scope = scope
+	}
+	return c, nil
+}
+
+//requestScope derives token scope of registry API request: pull for reads, pull and push for writes.
+//Cross repository mounts additionally need pull access to source repository. Empty scope is returned for other requests
+func requestScope(req *http.Request) string {
+	if req.URL.Path == "/v2/_catalog" {
+		return "registry:catalog:*"
+	}
+	m := repositoryPathRegexp.FindStringSubmatch(req.URL.Path)
+	if m == nil {
+		return ""
+	}
+	scope := "repository:" + m[1] + ":pull"
+	if req.Method != "GET" && req.Method != "HEAD" {
+		scope = scope + ",push"
+	}
+	if mount := mountScope(req); mount != "" {
+		scope = scope + " " + mount
+	}
+	return scope
+}
+
+//mountScope returns pull scope of source repository for cross repository mount requests
+func mountScope(req *http.Request) string {
+	if from := req.URL.Query().Get("from"); from != "" && req.URL.Query().Get("mount") != "" {
+		return "repository:" + from + ":pull"
+	}
+	return ""
+}
+
+func (c *challenge) key() string {
+	return c.realm + " " + c.service + " " + c.scope
+}
+
+//forget removes cached token of the challenge when it is the one registry refused
+func (t *authTransport) forget(c *challenge, refused string) {
+	t.mu.Lock()
+	if cached, ok := t.tokens[c.key()]; ok && cached.token == refused {
+		delete(t.tokens, c.key())
+	}
+	t.mu.Unlock()
+}
+
+//alias caches token of challenge c under scope of challenge requested
+func (t *authTransport) alias(requested *challenge, c *challenge) {
+	t.mu.Lock()
+	if cached, ok := t.tokens[c.key()]; ok {
+		t.tokens[requested.key()] = cached
+	}
+	t.mu.Unlock()
+}
+
+//token returns cached token of the challenge, requesting a new one when it is missing or about to expire. Authorization
+//service is asked outside of the lock, and concurrent requests of the same challenge wait for a single token request
+func (t *authTransport) token(ctx context.Context, c *challenge) (string, error) {
+	key := c.key()
+	for {
+		t.mu.Lock()
+		if cached, ok := t.tokens[key]; ok && time.Now().Add(tokenRefreshMargin).Before(cached.expires) {
+			t.mu.Unlock()
+			return cached.token, nil
+		}
+		fetch, ok := t.fetches[key]
+		if !ok {
+			fetch = &tokenFetch{done: make(chan struct{})}
+			if t.fetches == nil {
+				t.fetches = make(map[string]*tokenFetch)
+			}
+			t.fetches[key] = fetch
+			t.mu.Unlock()
+			fetch.token, fetch.err = t.fetchToken(ctx, c)
+			t.mu.Lock()
+			delete(t.fetches, key)
+			t.mu.Unlock()
+			close(fetch.done)
+			return fetch.token, fetch.err
+		}
+		t.mu.Unlock()
+		select {
+		case <-fetch.done:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		//Request which asked for the token was cancelled, so token is requested again
+		if (errors.Is(fetch.err, context.Canceled) || errors.Is(fetch.err, context.DeadlineExceeded)) && ctx.Err() == nil {
+			continue
+		}
+		return fetch.token, fetch.err
+	}
+}
+
+//fetchToken requests token of the challenge from authorization service and caches it
+func (t *authTransport) fetchToken(ctx context.Context, c *challenge) (string, error) {
+	if t.credentials.IdentityToken != "" {
+		t.refreshMu.Lock()
+		defer t.refreshMu.Unlock()
+	}
+	t.mu.Lock()
+	refreshToken := t.refreshToken
+	t.mu.Unlock()
+	resp, err := t.requestToken(ctx, c, refreshToken)
+	if err != nil {
+		return "", err
+	}
+	token := resp.Token
+	if token == "" {
+		token = resp.AccessToken
+	}
+	if token == "" {
+		return "", errors.New("authorization service " + c.realm + " returned empty token")
+	}
+	issued := resp.IssuedAt
+	if issued.IsZero() || issued.After(time.Now()) {
+		issued = time.Now()
+	}
+	lifetime := defaultTokenLifetime
+	if resp.ExpiresIn > 0 {
+		lifetime = time.Duration(resp.ExpiresIn) * time.Second
+	}
+	t.mu.Lock()
+	if resp.RefreshToken != "" && t.credentials.IdentityToken != "" {
+		t.refreshToken = resp.RefreshToken
+	}
+	if t.tokens == nil {
+		t.tokens = make(map[string]*cachedToken)
+	}
+	t.tokens[c.key()] = &cachedToken{token: token, expires: issued.Add(lifetime)}
+	t.mu.Unlock()
+	logf("connection.token realm=%s service=%s scope=%q expires_in=%s", c.realm, c.service, c.scope, lifetime)
+	return token, nil
+}
+
+//requestToken requests token from authorization service. Identity token from docker login is OAuth2 refresh token, which is exchanged
+//using refresh token grant (authorization service may rotate it), otherwise token is requested with basic credentials or anonymously
+func (t *authTransport) requestToken(ctx context.Context, c *challenge, refreshToken string) (*tokenResponse, error) {
+	if refreshToken == "" {
+		refreshToken = t.credentials.IdentityToken
+	}
+	var tokenReq *http.Request
+	var err error
+	if refreshToken != "" {
+		form := url.Values{}
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", refreshToken)
+		form.Set("service", c.service)
+		form.Set("client_id", clientID)
+		if c.scope != "" {
+			form.Set("scope", c.scope)
+		}
+		tokenReq, err = http.NewRequest("POST", c.realm, strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		tokenReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		tokenURL, err := url.Parse(c.realm)
+		if err != nil {
+			return nil, err
+		}
+		q := tokenURL.Query()
+		q.Set("service", c.service)
+		for _, scope := range strings.Fields(c.scope) {
+			q.Add("scope", scope)
+		}
+		tokenURL.RawQuery = q.Encode()
+		tokenReq, err = http.NewRequest("GET", tokenURL.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		if t.credentials.Username != "" || t.credentials.Password != "" {
+			tokenReq.SetBasicAuth(t.credentials.Username, t.credentials.Password)
+		}
+	}
+	resp, err := t.transport.RoundTrip(tokenReq.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("authorization service %s refused token (status=%d body=%q)", c.realm, resp.StatusCode, body)
+	}
+	token := &tokenResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+//bearerChallenge returns bearer challenge of unauthorized response
+func bearerChallenge(resp *http.Response) *challenge {
+	if resp.StatusCode != http.StatusUnauthorized {
+		return nil
+	}
+	for _, header := range resp.Header[http.CanonicalHeaderKey("WWW-Authenticate")] {
+		if !strings.HasPrefix(strings.ToLower(header), "bearer ") {
+			continue
+		}
+		c := &challenge{}
+		for _, param := range challengeParamRegexp.FindAllStringSubmatch(header, -1) {
+			switch strings.ToLower(param[1]) {
+			case "realm":
+				c.realm = param[2]
+			case "service":
+				c.service = param[2]
+			case "scope":
+				c.scope = param[2]
+			}
+		}
+		if c.realm != "" {
+			return c
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package connection
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//uploadHost is upload target host which records PUT requests. Bearer host asks for token on /v2/
+type uploadHost struct {
+	mu     sync.Mutex
+	bearer bool
+	puts   []string
+	auth   string
+	scope  string
+}
+
+func (u *uploadHost) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	switch {
+	case r.URL.Path == "/token":
+		u.scope = r.URL.Query().Get("scope")
+		w.Write([]byte(`{"token": "upload-token"}`))
+	case r.URL.Path == "/v2/" && u.bearer:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="http://`+r.Host+`/token",service="storage"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	case r.URL.Path == "/v2/":
+		w.WriteHeader(http.StatusOK)
+	case r.Method == "PUT":
+		body, _ := ioutil.ReadAll(r.Body)
+		u.puts = append(u.puts, string(body))
+		u.auth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestStreamedRequestOnOtherHost(t *testing.T) {
+	tests := []struct {
+		bearer bool
+		auth   string
+		scope  string
+	}{
+		{true, "Bearer upload-token", "repository:team/app:pull,push"},
+		{false, "", ""},
+	}
+	for _, test := range tests {
+		u := &uploadHost{bearer: test.bearer}
+		server := httptest.NewServer(u)
+		transport := &authTransport{
+			transport: http.DefaultTransport,
+			url:       "https://registry.corp",
+			service:   &challenge{realm: "https://auth.corp/token", service: "registry.corp"},
+		}
+		//Upload location points to another host, so token of the registry is not used
+		req, err := http.NewRequest("PUT", server.URL+"/v2/team/app/blobs/uploads/session?digest=sha256:abc", io.MultiReader(strings.NewReader("layer")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := transport.RoundTrip(req)
+		server.Close()
+		if err != nil {
+			t.Errorf("bearer %v: unexpected error: %v", test.bearer, err)
+			continue
+		}
+		resp.Body.Close()
+		if len(u.puts) != 1 || u.puts[0] != "layer" {
+			t.Errorf("bearer %v: host received PUT requests %q, expected single request with layer data", test.bearer, u.puts)
+		}
+		if u.auth != test.auth || u.scope != test.scope {
+			t.Errorf("bearer %v: authorization %q with scope %q, expected %q with scope %q", test.bearer, u.auth, u.scope, test.auth, test.scope)
+		}
+	}
+}
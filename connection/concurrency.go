@@ -0,0 +1,149 @@
+package connection
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/heroku/docker-registry-client/registry"
+	"github.com/vbaksa/promoter/reference"
+)
+
+//Default number of workers of promotion pools
+const (
+	DefaultManifestWorkers = 5
+	DefaultMetadataWorkers = 10
+	DefaultExistsWorkers   = 5
+	DefaultUploadWorkers   = 5
+)
+
+//Concurrency configures number of workers of promotion pools. Zero values use defaults
+type Concurrency struct {
+	//Manifests is number of manifests fetched, compared or pushed concurrently
+	Manifests int
+	//Metadata is number of concurrent layer metadata requests to source registry
+	Metadata int
+	//Exists is number of concurrent layer existence checks and mounts in destination registry
+	Exists int
+	//Uploads is number of layers transferred concurrently
+	Uploads int
+	//MaxPerRegistry caps concurrent operations against single registry host, shared by all pools. No limit when zero
+	MaxPerRegistry int
+	//RegistryMax overrides MaxPerRegistry for registry hosts e.g. docker.io
+	RegistryMax map[string]int
+}
+
+//WithDefaults returns concurrency with default number of workers in place of zero values
+func (c Concurrency) WithDefaults() Concurrency {
+	if c.Manifests <= 0 {
+		c.Manifests = DefaultManifestWorkers
+	}
+	if c.Metadata <= 0 {
+		c.Metadata = DefaultMetadataWorkers
+	}
+	if c.Exists <= 0 {
+		c.Exists = DefaultExistsWorkers
+	}
+	if c.Uploads <= 0 {
+		c.Uploads = DefaultUploadWorkers
+	}
+	return c
+}
+
+//HostLimiter caps number of concurrent operations per registry host. Operation accessing two registries, e.g. layer
+//transfer, holds a slot of both of them. Nil limiter does not limit anything
+type HostLimiter struct {
+	limit  int
+	limits map[string]int
+
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+//NewHostLimiter creates limiter of MaxPerRegistry and RegistryMax settings
+func NewHostLimiter(c Concurrency) *HostLimiter {
+	return &HostLimiter{limit: c.MaxPerRegistry, limits: c.RegistryMax, slots: make(map[string]chan struct{})}
+}
+
+//Acquire waits for a slot of every registry. Slots are reserved in the same order by all operations, so operations
+//accessing the same registries do not deadlock. Returned function releases the slots
+func (l *HostLimiter) Acquire(ctx context.Context, hubs ...*registry.Registry) (func(), error) {
+	acquired := make([]chan struct{}, 0, len(hubs))
+	release := func() {
+		for _, slots := range acquired {
+			<-slots
+		}
+	}
+	if l == nil {
+		return release, nil
+	}
+	for _, host := range hubHosts(hubs) {
+		slots := l.semaphore(host)
+		if slots == nil {
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+			acquired = append(acquired, slots)
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
+
+//Workers limits number of pool workers accessing single registry by the registry limit
+func (l *HostLimiter) Workers(workers int, hub *registry.Registry) int {
+	if l == nil || hub == nil {
+		return workers
+	}
+	if limit := l.hostLimit(registryHost(hub.URL)); limit > 0 && limit < workers {
+		return limit
+	}
+	return workers
+}
+
+//semaphore returns slots of registry host, nil when host is not limited
+func (l *HostLimiter) semaphore(host string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if slots, ok := l.slots[host]; ok {
+		return slots
+	}
+	var slots chan struct{}
+	if limit := l.hostLimit(host); limit > 0 {
+		slots = make(chan struct{}, limit)
+	}
+	l.slots[host] = slots
+	return slots
+}
+
+//hostLimit returns limit of registry host. Docker Hub limit can be keyed by any of its names
+func (l *HostLimiter) hostLimit(host string) int {
+	for key, limit := range l.limits {
+		if registryHost(key) == host || (reference.IsDockerHub(registryHost(key)) && reference.IsDockerHub(host)) {
+			return limit
+		}
+	}
+	return l.limit
+}
+
+//hubHosts returns sorted unique hosts of registries
+func hubHosts(hubs []*registry.Registry) []string {
+	hosts := make([]string, 0, len(hubs))
+	seen := make(map[string]bool)
+	for _, hub := range hubs {
+		if hub == nil {
+			continue
+		}
+		host := registryHost(strings.TrimSuffix(hub.URL, "/"))
+		if !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
@@ -2,62 +2,132 @@ package connection
 
 import (
 	"fmt"
-
-	"os"
+	"io"
+	"net"
+	"net/http"
+	"strings"
 
 	"github.com/heroku/docker-registry-client/registry"
 )
 
-type connectionResult struct {
-	srcHub  *registry.Registry
-	destHub *registry.Registry
-	err     error
+//Config describes how to connect to registry
+type Config struct {
+	//URL is registry URL. When scheme is omitted, HTTPS is tried first and HTTP is used only for insecure registries
+	URL      string
+	Username string
+	Password string
+	TLS      TLSConfig
+	//InsecureRegistries lists hosts (host[:port]) and CIDR networks which may be reached over plain HTTP
+	InsecureRegistries []string
+	//Proxy is http://, https:// or socks5:// proxy URL or DirectProxy. Proxy environment variables are honored when empty
+	Proxy string
+	//Mirrors lists pull mirrors of the registry, see ConnectMirrors
+	Mirrors []string
+	//Retry configures retries of requests failing with transient errors
+	Retry RetryPolicy
+	//Output receives connection messages e.g. registry in use or retried request. Nothing is printed when nil.
+	//Pooled registry keeps output of the configuration it was connected with
+	Output io.Writer
 }
 
 //InitConnection initializes connections to specified registries
-func InitConnection(srcRegistry string, srcUsername string, srcPassword string, srcInsecure bool, destRegistry string, destUsername string, destPassword string, destInsecure bool) (*registry.Registry, *registry.Registry) {
-	fmt.Println("Establishing connections...")
-	var srcHub *registry.Registry
-	var destHub *registry.Registry
-	res := make(chan *connectionResult)
-	go connect(srcRegistry, srcUsername, srcPassword, srcInsecure, true, res)
-	go connect(destRegistry, destUsername, destPassword, destInsecure, false, res)
-	for index := 0; index < 2; index++ {
-		reg := <-res
-		if reg.err != nil {
-			os.Exit(1)
-		} else {
-			if reg.destHub != nil {
-				destHub = reg.destHub
-			}
-			if reg.srcHub != nil {
-				srcHub = reg.srcHub
-			}
+func InitConnection(src Config, dest Config) (*registry.Registry, *registry.Registry, error) {
+	var pool *Pool
+	return pool.InitConnection(src, dest)
+}
+
+//ConnectMirrors connects to pull mirrors of source registry, which are tried before the registry itself. Mirrors are accessed
+//with their own credentials from Docker config and proxy environment variables. Unreachable mirrors are skipped
+func ConnectMirrors(src Config) []*registry.Registry {
+	var pool *Pool
+	return pool.ConnectMirrors(src)
+}
 
+//newRegistry builds registry client authenticated with explicit or Docker config credentials and pings the registry.
+//Registry scheme is negotiated when URL does not specify it
+func newRegistry(config Config) (*registry.Registry, error) {
+	credentials, err := ResolveCredentials(config.URL, config.Username, config.Password)
+	if err != nil {
+		return nil, err
+	}
+	tc, err := tlsConfig(registryHost(config.URL), config.TLS)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tc
+	if transport.Proxy, err = proxyFunc(registryHost(config.URL), config.Proxy); err != nil {
+		return nil, err
+	}
+	retry := &retryTransport{transport: transport, policy: config.Retry.WithDefaults(), output: config.Output}
+	if strings.Contains(config.URL, "://") {
+		hub := buildRegistry(config.URL, retry, credentials)
+		if err := hub.Ping(); err != nil {
+			return nil, err
+		}
+		config.printf("Using %s\n", hub.URL)
+		return hub, nil
+	}
+	host := strings.TrimSuffix(config.URL, "/")
+	hub := buildRegistry("https://"+host, retry, credentials)
+	err = hub.Ping()
+	if err != nil && isInsecureRegistry(host, config.InsecureRegistries) {
+		logf("connection.negotiate registry=%s https error=%s", host, err.Error())
+		hub = buildRegistry("http://"+host, retry, credentials)
+		if httpErr := hub.Ping(); httpErr != nil {
+			return nil, fmt.Errorf("%s. Plain HTTP failed as well: %w", err.Error(), httpErr)
 		}
+		err = nil
 	}
+	if err != nil {
+		return nil, err
+	}
+	config.printf("Using %s\n", hub.URL)
+	return hub, nil
+}
 
-	return srcHub, destHub
+//printf writes message to configuration output
+func (c Config) printf(format string, args ...interface{}) {
+	if c.Output != nil {
+		fmt.Fprintf(c.Output, format, args...)
+	}
 }
-func connect(url string, username string, password string, insecure bool, src bool, ch chan *connectionResult) {
-	var hub *registry.Registry
-	var err error
-	res := &connectionResult{}
-	if insecure {
-		hub, err = registry.NewInsecure(url, username, password)
 
-	} else {
-		hub, err = registry.New(url, username, password)
+func buildRegistry(registryURL string, transport http.RoundTripper, credentials Credentials) *registry.Registry {
+	registryURL = strings.TrimSuffix(registryURL, "/")
+	return &registry.Registry{
+		URL: registryURL,
+		Client: &http.Client{
+			Transport: &registry.ErrorTransport{
+				Transport: &authTransport{
+					transport:   transport,
+					url:         registryURL,
+					credentials: credentials,
+				},
+			},
+		},
+		Logf: logf,
 	}
-	if err != nil {
-		res.err = err
-		fmt.Println("Cannot connect to registry: " + url)
-		fmt.Println("Connection error: " + err.Error())
+}
+
+//isInsecureRegistry checks registry host against allow-list. Like Docker, loopback registries are always allowed
+func isInsecureRegistry(host string, insecureRegistries []string) bool {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	hostname = strings.Trim(hostname, "[]")
+	ip := net.ParseIP(hostname)
+	if hostname == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return true
 	}
-	if src {
-		res.srcHub = hub
-	} else {
-		res.destHub = hub
+	for _, entry := range insecureRegistries {
+		if strings.EqualFold(entry, host) || strings.EqualFold(entry, hostname) {
+			return true
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil && ip != nil && network.Contains(ip) {
+			return true
+		}
 	}
-	ch <- res
+	return false
 }
@@ -0,0 +1,173 @@
+package connection
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/vbaksa/promoter/reference"
+)
+
+//dockerHubServer is the key Docker uses to store Docker Hub credentials
+const dockerHubServer = "https://index.docker.io/v1/"
+
+//Credentials are used to authenticate against registry
+type Credentials struct {
+	Username string
+	Password string
+	//IdentityToken is OAuth2 refresh token stored by docker login. It is exchanged for registry tokens instead of password
+	IdentityToken string
+}
+
+//dockerConfig covers credential related fields of Docker config.json
+type dockerConfig struct {
+	Auths       map[string]dockerAuth `json:"auths"`
+	CredsStore  string                `json:"credsStore"`
+	CredHelpers map[string]string     `json:"credHelpers"`
+}
+
+type dockerAuth struct {
+	Auth          string `json:"auth"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identitytoken"`
+}
+
+//helperCredentials is the response of credential helper get command
+type helperCredentials struct {
+	ServerURL string
+	Username  string
+	Secret    string
+}
+
+//ResolveCredentials returns credentials for specified registry. Explicitly provided username or password take precedence,
+//otherwise credentials are looked up in Docker config.json (credential helpers, credential store and auths in that order)
+func ResolveCredentials(registryURL string, username string, password string) (Credentials, error) {
+	if username != "" || password != "" {
+		return Credentials{Username: username, Password: password}, nil
+	}
+	config, err := loadDockerConfig()
+	if err != nil || config == nil {
+		return Credentials{}, err
+	}
+	host := registryHost(registryURL)
+	server := host
+	if reference.IsDockerHub(host) {
+		server = dockerHubServer
+	}
+	if helper, ok := config.CredHelpers[host]; ok {
+		return helperGet(helper, server)
+	}
+	if config.CredsStore != "" {
+		creds, err := helperGet(config.CredsStore, server)
+		if err != nil || creds != (Credentials{}) {
+			return creds, err
+		}
+	}
+	for key, auth := range config.Auths {
+		if registryHost(key) == host || (reference.IsDockerHub(host) && reference.IsDockerHub(registryHost(key))) {
+			log.Printf("connection.credentials registry=%s source=config.json", host)
+			return auth.credentials()
+		}
+	}
+	return Credentials{}, nil
+}
+
+//loadDockerConfig reads config.json from DOCKER_CONFIG directory or ~/.docker. Missing config is not an error
+func loadDockerConfig() (*dockerConfig, error) {
+	dir := dockerConfigDir()
+	if dir == "" {
+		return nil, nil
+	}
+	path := filepath.Join(dir, "config.json")
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read Docker config %s: %w", path, err)
+	}
+	config := &dockerConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("cannot parse Docker config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+//dockerConfigDir returns DOCKER_CONFIG directory or ~/.docker
+func dockerConfigDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".docker")
+}
+
+//credentials decodes base64 encoded username:password pair
+func (a dockerAuth) credentials() (Credentials, error) {
+	creds := Credentials{Username: a.Username, Password: a.Password, IdentityToken: a.IdentityToken}
+	if a.Auth == "" {
+		return creds, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(a.Auth)
+	if err != nil {
+		return Credentials{}, errors.New("invalid auth entry in Docker config: " + err.Error())
+	}
+	s := strings.SplitN(string(decoded), ":", 2)
+	if len(s) != 2 {
+		return Credentials{}, errors.New("invalid auth entry in Docker config: username and password should be separated by colon")
+	}
+	creds.Username = s[0]
+	creds.Password = s[1]
+	return creds, nil
+}
+
+//helperGet asks docker-credential-<helper> for credentials of the server. Unknown server results in empty credentials
+func helperGet(helper string, server string) (Credentials, error) {
+	log.Printf("connection.credentials registry=%s source=docker-credential-%s", server, helper)
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		output := strings.TrimSpace(stdout.String() + stderr.String())
+		if strings.Contains(strings.ToLower(output), "credentials not found") {
+			return Credentials{}, nil
+		}
+		return Credentials{}, fmt.Errorf("credential helper docker-credential-%s failed: %s: %w", helper, output, err)
+	}
+	var resp helperCredentials
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return Credentials{}, fmt.Errorf("credential helper docker-credential-%s returned invalid response: %w", helper, err)
+	}
+	//Helpers return identity tokens with special username
+	if resp.Username == "<token>" {
+		return Credentials{IdentityToken: resp.Secret}, nil
+	}
+	return Credentials{Username: resp.Username, Password: resp.Secret}, nil
+}
+
+//registryHost returns host (with port) of registry URL or Docker config key e.g. https://index.docker.io/v1/
+func registryHost(s string) string {
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	return strings.ToLower(u.Host)
+}
@@ -0,0 +1,128 @@
+package connection
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//testHelpers are credential helper scripts used by tests. Store helper knows only registry.store
+var testHelpers = map[string]string{
+	"docker-credential-store": `#!/bin/sh
+read server
+if [ "$server" = "registry.store" ]; then
+	echo '{"ServerURL":"registry.store","Username":"storeuser","Secret":"storepass"}'
+	exit 0
+fi
+echo "credentials not found in native keychain"
+exit 1
+`,
+	"docker-credential-token": `#!/bin/sh
+read server
+echo '{"ServerURL":"'$server'","Username":"<token>","Secret":"refresh-token"}'
+`,
+	"docker-credential-broken": `#!/bin/sh
+echo "keychain locked" >&2
+exit 1
+`,
+}
+
+//tempDir creates temporary directory removed by returned function
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "promoter-credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+//setenv sets environment variable and returns function restoring its previous value
+func setenv(key string, value string) func() {
+	previous, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if ok {
+			os.Setenv(key, previous)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func TestResolveCredentials(t *testing.T) {
+	bin, removeBin := tempDir(t)
+	defer removeBin()
+	for name, script := range testHelpers {
+		if err := ioutil.WriteFile(filepath.Join(bin, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))()
+	dir, removeDir := tempDir(t)
+	defer removeDir()
+	defer setenv("DOCKER_CONFIG", dir)()
+	auth := base64.StdEncoding.EncodeToString([]byte("authuser:auth:pass"))
+	config := `{
+		"auths": {
+			"https://index.docker.io/v1/": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("hubuser:hubpass")) + `"},
+			"registry.auth:5000": {"auth": "` + auth + `"},
+			"https://registry.identity": {"identitytoken": "identity"},
+			"registry.store": {"auth": "` + auth + `"},
+			"registry.invalid": {"auth": "not base64"}
+		},
+		"credsStore": "store",
+		"credHelpers": {
+			"registry.token": "token",
+			"registry.broken": "broken"
+		}
+	}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		registry string
+		username string
+		password string
+		expected Credentials
+		valid    bool
+	}{
+		{"https://registry.auth:5000", "flaguser", "flagpass", Credentials{Username: "flaguser", Password: "flagpass"}, true},
+		{"https://registry.auth:5000", "", "", Credentials{Username: "authuser", Password: "auth:pass"}, true},
+		{"https://REGISTRY.AUTH:5000/", "", "", Credentials{Username: "authuser", Password: "auth:pass"}, true},
+		{"https://registry-1.docker.io", "", "", Credentials{Username: "hubuser", Password: "hubpass"}, true},
+		{"https://registry.identity", "", "", Credentials{IdentityToken: "identity"}, true},
+		{"https://registry.store", "", "", Credentials{Username: "storeuser", Password: "storepass"}, true},
+		{"https://registry.token", "", "", Credentials{IdentityToken: "refresh-token"}, true},
+		{"https://registry.unknown", "", "", Credentials{}, true},
+		{"https://registry.invalid", "", "", Credentials{}, false},
+		{"https://registry.broken", "", "", Credentials{}, false},
+	}
+	for _, test := range tests {
+		creds, err := ResolveCredentials(test.registry, test.username, test.password)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%s: expected error, got %+v", test.registry, creds)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.registry, err)
+			continue
+		}
+		if creds != test.expected {
+			t.Errorf("%s: credentials %+v, expected %+v", test.registry, creds, test.expected)
+		}
+	}
+}
+
+func TestResolveCredentialsWithoutConfig(t *testing.T) {
+	dir, removeDir := tempDir(t)
+	defer removeDir()
+	defer setenv("DOCKER_CONFIG", dir)()
+	creds, err := ResolveCredentials("https://registry.corp", "", "")
+	if err != nil || creds != (Credentials{}) {
+		t.Errorf("credentials %+v, error %v, expected no credentials", creds, err)
+	}
+}
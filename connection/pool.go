@@ -0,0 +1,147 @@
+package connection
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/heroku/docker-registry-client/registry"
+)
+
+//Pool shares registry connections between promotions run by the same process, so HTTP connections and bearer tokens
+//are reused. Registries are reused only when connected with identical configuration. Nil pool connects every time
+type Pool struct {
+	mu   sync.Mutex
+	hubs map[string]*registry.Registry
+	//connecting are connections in progress by configuration key
+	connecting map[string]*poolConnection
+	limiter    *HostLimiter
+}
+
+//poolConnection is connection in progress, shared by concurrent promotions connecting with the same configuration
+type poolConnection struct {
+	done chan struct{}
+	hub  *registry.Registry
+	err  error
+}
+
+type connectionResult struct {
+	srcHub  *registry.Registry
+	destHub *registry.Registry
+	err     error
+}
+
+//NewPool creates empty connection pool
+func NewPool() *Pool {
+	return &Pool{hubs: make(map[string]*registry.Registry), connecting: make(map[string]*poolConnection)}
+}
+
+//InitConnection initializes connections to specified registries, reusing registries connected before
+func (p *Pool) InitConnection(src Config, dest Config) (*registry.Registry, *registry.Registry, error) {
+	src.printf("Establishing connections...\n")
+	var srcHub *registry.Registry
+	var destHub *registry.Registry
+	res := make(chan *connectionResult)
+	go p.connect(src, true, res)
+	go p.connect(dest, false, res)
+	var err error
+	for index := 0; index < 2; index++ {
+		reg := <-res
+		if reg.err != nil {
+			err = reg.err
+		} else {
+			if reg.destHub != nil {
+				destHub = reg.destHub
+			}
+			if reg.srcHub != nil {
+				srcHub = reg.srcHub
+			}
+
+		}
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+	return srcHub, destHub, nil
+}
+
+//ConnectMirrors connects to pull mirrors of source registry, reusing mirrors connected before. Unreachable mirrors are skipped
+func (p *Pool) ConnectMirrors(src Config) []*registry.Registry {
+	mirrors := make([]*registry.Registry, 0, len(src.Mirrors))
+	for _, mirror := range src.Mirrors {
+		hub, err := p.registry(Config{URL: mirror, InsecureRegistries: src.InsecureRegistries, Retry: src.Retry, Output: src.Output})
+		if err != nil {
+			src.printf("Mirror %s is not available, skipping it. Error: %s \n", mirror, Redact(err.Error()))
+			continue
+		}
+		mirrors = append(mirrors, hub)
+	}
+	return mirrors
+}
+
+func (p *Pool) connect(config Config, src bool, ch chan *connectionResult) {
+	res := &connectionResult{}
+	hub, err := p.registry(config)
+	if err != nil {
+		res.err = fmt.Errorf("cannot connect to registry %s: %w", config.URL, err)
+	}
+	if src {
+		res.srcHub = hub
+	} else {
+		res.destHub = hub
+	}
+	ch <- res
+}
+
+//Limiter returns registry host limiter shared by promotions of the pool. Limiter is created by the first call,
+//so promotions of the process should use the same concurrency settings. Nil pool creates new limiter every time
+func (p *Pool) Limiter(c Concurrency) *HostLimiter {
+	if p == nil {
+		return NewHostLimiter(c)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.limiter == nil {
+		p.limiter = NewHostLimiter(c)
+	}
+	return p.limiter
+}
+
+//registry returns pooled registry of the configuration or connects to it. Registry is connected outside of the lock
+//and concurrent callers with the same configuration wait for the single connection. Failed connections are not pooled
+func (p *Pool) registry(config Config) (*registry.Registry, error) {
+	if p == nil {
+		return newRegistry(config)
+	}
+	//Mirrors and output do not affect connection itself
+	key := fmt.Sprintf("%q %q %q %+v %q %q %+v", config.URL, config.Username, config.Password, config.TLS, config.InsecureRegistries, config.Proxy, config.Retry)
+	p.mu.Lock()
+	if hub, ok := p.hubs[key]; ok {
+		p.mu.Unlock()
+		config.printf("Using %s\n", hub.URL)
+		return hub, nil
+	}
+	if conn, ok := p.connecting[key]; ok {
+		p.mu.Unlock()
+		<-conn.done
+		return conn.hub, conn.err
+	}
+	conn := &poolConnection{done: make(chan struct{})}
+	if p.connecting == nil {
+		p.connecting = make(map[string]*poolConnection)
+	}
+	p.connecting[key] = conn
+	p.mu.Unlock()
+	conn.hub, conn.err = newRegistry(config)
+	p.mu.Lock()
+	delete(p.connecting, key)
+	if conn.err == nil {
+		if p.hubs == nil {
+			p.hubs = make(map[string]*registry.Registry)
+		}
+		p.hubs[key] = conn.hub
+	}
+	p.mu.Unlock()
+	close(conn.done)
+	return conn.hub, conn.err
+}
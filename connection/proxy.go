@@ -0,0 +1,36 @@
+package connection
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+//DirectProxy disables proxy for registry, even if proxy environment variables are set
+const DirectProxy = "direct"
+
+//proxyFunc returns proxy selection function of transport. Empty proxy honors HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment variables.
+//Proxy credentials are taken from proxy URL user information
+func proxyFunc(host string, proxy string) (func(*http.Request) (*url.URL, error), error) {
+	switch proxy {
+	case "":
+		return http.ProxyFromEnvironment, nil
+	case DirectProxy:
+		logf("connection.proxy registry=%s proxy=direct", host)
+		return nil, nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, errors.New("invalid proxy " + Redact(proxy) + ": " + Redact(err.Error()))
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, errors.New("invalid proxy " + Redact(proxy) + ". Supported proxy schemes are http, https and socks5")
+	}
+	if u.Host == "" {
+		return nil, errors.New("invalid proxy " + Redact(proxy) + ". Proxy host is missing")
+	}
+	logf("connection.proxy registry=%s proxy=%s", host, proxy)
+	return http.ProxyURL(u), nil
+}
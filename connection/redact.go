@@ -0,0 +1,27 @@
+package connection
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+)
+
+//Redacted replaces secrets in debug output
+const Redacted = "*****"
+
+var (
+	//secretParamRegexp matches URL parameters carrying tokens or signatures e.g. pre-signed storage URLs registries redirect to
+	secretParamRegexp = regexp.MustCompile(`(?i)([?&][\w-]*(?:token|password|secret|signature|credential)[\w-]*=)[^&\s]+`)
+	userinfoRegexp    = regexp.MustCompile(`(://[^/:@\s]+):[^/@\s]+@`)
+)
+
+//Redact removes secrets from text printed in debug output
+func Redact(s string) string {
+	s = secretParamRegexp.ReplaceAllString(s, "${1}"+Redacted)
+	return userinfoRegexp.ReplaceAllString(s, "${1}:"+Redacted+"@")
+}
+
+//logf logs registry requests with secrets removed
+func logf(format string, args ...interface{}) {
+	log.Print(Redact(fmt.Sprintf(format, args...)))
+}
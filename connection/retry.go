@@ -0,0 +1,188 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+//Default retry policy of registry requests
+const (
+	DefaultRetryAttempts = 5
+	DefaultRetryMinDelay = time.Second
+	DefaultRetryMaxDelay = 30 * time.Second
+)
+
+var (
+	blobPathRegexp     = regexp.MustCompile(`^/v2/(.+)/blobs/([^/]+)$`)
+	manifestPathRegexp = regexp.MustCompile(`^/v2/(.+)/manifests/([^/]+)$`)
+)
+
+//RetryPolicy configures retries of registry requests failing with transient errors. Zero values use defaults
+type RetryPolicy struct {
+	//Attempts is maximum number of attempts of single request, 1 disables retries
+	Attempts int
+	//MinDelay is delay before the first retry. Delay doubles with every retry up to MaxDelay and is randomized by up to half
+	MinDelay time.Duration
+	MaxDelay time.Duration
+}
+
+//WithDefaults returns policy with default values in place of zero values
+func (p RetryPolicy) WithDefaults() RetryPolicy {
+	if p.Attempts <= 0 {
+		p.Attempts = DefaultRetryAttempts
+	}
+	if p.MinDelay <= 0 {
+		p.MinDelay = DefaultRetryMinDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultRetryMaxDelay
+	}
+	if p.MaxDelay < p.MinDelay {
+		p.MaxDelay = p.MinDelay
+	}
+	return p
+}
+
+//delay returns jittered exponential backoff before retry following specified attempt. Retry-After of 429 and 503
+//responses is honored when it asks for longer delay
+func (p RetryPolicy) delay(attempt int, resp *http.Response) time.Duration {
+	backoff := p.MinDelay
+	for i := 1; i < attempt && backoff < p.MaxDelay; i++ {
+		backoff = backoff * 2
+	}
+	if backoff > p.MaxDelay {
+		backoff = p.MaxDelay
+	}
+	backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+	if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		if after, ok := retryAfter(resp.Header.Get("Retry-After")); ok && after > backoff {
+			return after
+		}
+	}
+	return backoff
+}
+
+//retryTransport repeats registry requests failing with transient errors, see RetryPolicy
+type retryTransport struct {
+	transport http.RoundTripper
+	policy    RetryPolicy
+	//output receives retry messages, nothing is printed when nil
+	output io.Writer
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := t.transport.RoundTrip(req)
+		if attempt >= t.policy.Attempts || !retryableRequest(req) || !retryable(resp, err) {
+			return resp, err
+		}
+		delay := t.policy.delay(attempt, resp)
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
+		}
+		if t.output != nil {
+			fmt.Fprintf(t.output, "Retrying %s in %s (attempt %d of %d): %s \n", requestTarget(req), delay.Round(time.Millisecond), attempt+1, t.policy.Attempts, Redact(reason))
+		}
+		logf("connection.retry method=%s url=%s attempt=%d delay=%s error=%s", req.Method, req.URL, attempt+1, delay, reason)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+		if req.Body != nil && req.Body != http.NoBody {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+//retryableRequest reports whether request can be sent again. Streamed bodies can not be replayed and chunk uploads
+//are resumed by layer upload itself, because registry may have received part of the chunk
+func retryableRequest(req *http.Request) bool {
+	if req.Context().Err() != nil || req.Method == "PATCH" {
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+//retryable reports whether request failed with transient error: gateway errors, throttling and broken connections
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return retryableError(err)
+	}
+	switch resp.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+//retryableError reports whether network error is transient. Refused connections and TLS errors are not, so registry
+//scheme negotiation is not slowed down
+func retryableError(err error) bool {
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+//retryAfter parses Retry-After header given either in seconds or as HTTP date
+func retryAfter(header string) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		if after := time.Until(date); after > 0 {
+			return after, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+//requestTarget describes request for retry messages, naming blob digest or manifest reference when request has one
+func requestTarget(req *http.Request) string {
+	if digest := req.URL.Query().Get("digest"); digest != "" {
+		return req.Method + " blob " + digest
+	}
+	if m := blobPathRegexp.FindStringSubmatch(req.URL.Path); m != nil {
+		return req.Method + " blob " + m[2] + " of " + m[1]
+	}
+	if m := manifestPathRegexp.FindStringSubmatch(req.URL.Path); m != nil {
+		if strings.Contains(m[2], ":") {
+			return req.Method + " manifest " + m[1] + "@" + m[2]
+		}
+		return req.Method + " manifest " + m[1] + ":" + m[2]
+	}
+	return req.Method + " " + req.URL.Scheme + "://" + req.URL.Host + req.URL.Path
+}
@@ -0,0 +1,168 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+//timeoutError is network error reporting timeout
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		status    int
+		retryable bool
+	}{
+		{http.StatusOK, false},
+		{http.StatusCreated, false},
+		{http.StatusUnauthorized, false},
+		{http.StatusNotFound, false},
+		{http.StatusRequestTimeout, true},
+		{http.StatusTooManyRequests, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusNotImplemented, false},
+		{http.StatusBadGateway, true},
+		{http.StatusServiceUnavailable, true},
+		{http.StatusGatewayTimeout, true},
+	}
+	for _, test := range tests {
+		if retryable(&http.Response{StatusCode: test.status}, nil) != test.retryable {
+			t.Errorf("%d: retryable %v, expected %v", test.status, !test.retryable, test.retryable)
+		}
+	}
+}
+
+func TestRetryableError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{"connection reset", &url.Error{Op: "Get", URL: "https://registry.corp", Err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}}, true},
+		{"broken pipe", &net.OpError{Op: "write", Err: syscall.EPIPE}, true},
+		{"EOF", &url.Error{Op: "Get", URL: "https://registry.corp", Err: io.EOF}, true},
+		{"unexpected EOF", fmt.Errorf("read body: %w", io.ErrUnexpectedEOF), true},
+		{"timeout", &url.Error{Op: "Get", URL: "https://registry.corp", Err: timeoutError{}}, true},
+		{"temporary DNS error", &net.DNSError{Err: "server misbehaving", Name: "registry.corp", IsTemporary: true}, true},
+		{"DNS timeout", &net.DNSError{Err: "timeout", Name: "registry.corp", IsTimeout: true}, true},
+		{"unknown host", &net.DNSError{Err: "no such host", Name: "registry.corp", IsNotFound: true}, false},
+		{"connection refused", &url.Error{Op: "Get", URL: "https://registry.corp", Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}, false},
+		{"TLS error", &url.Error{Op: "Get", URL: "https://registry.corp", Err: errors.New("tls: first record does not look like a TLS handshake")}, false},
+		{"cancelled", &url.Error{Op: "Get", URL: "https://registry.corp", Err: context.Canceled}, false},
+	}
+	for _, test := range tests {
+		if retryableError(test.err) != test.retryable {
+			t.Errorf("%s: retryable %v, expected %v", test.name, !test.retryable, test.retryable)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		header string
+		after  time.Duration
+		valid  bool
+	}{
+		{"", 0, false},
+		{"0", 0, true},
+		{"120", 120 * time.Second, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, true},
+	}
+	for _, test := range tests {
+		after, ok := retryAfter(test.header)
+		if ok != test.valid || after != test.after {
+			t.Errorf("%q: delay %s %v, expected %s %v", test.header, after, ok, test.after, test.valid)
+		}
+	}
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if after, ok := retryAfter(date); !ok || after < 58*time.Second || after > time.Minute {
+		t.Errorf("%q: delay %s %v, expected about a minute", date, after, ok)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{MinDelay: time.Second, MaxDelay: 8 * time.Second}.WithDefaults()
+	tests := []struct {
+		attempt    int
+		retryAfter string
+		status     int
+		min        time.Duration
+		max        time.Duration
+	}{
+		{1, "", http.StatusBadGateway, 500 * time.Millisecond, time.Second},
+		{2, "", http.StatusBadGateway, time.Second, 2 * time.Second},
+		{3, "", http.StatusBadGateway, 2 * time.Second, 4 * time.Second},
+		{4, "", http.StatusBadGateway, 4 * time.Second, 8 * time.Second},
+		{10, "", http.StatusBadGateway, 4 * time.Second, 8 * time.Second},
+		{1, "20", http.StatusTooManyRequests, 20 * time.Second, 20 * time.Second},
+		{1, "20", http.StatusServiceUnavailable, 20 * time.Second, 20 * time.Second},
+		{1, "20", http.StatusBadGateway, 500 * time.Millisecond, time.Second},
+		{4, "1", http.StatusTooManyRequests, 4 * time.Second, 8 * time.Second},
+	}
+	for _, test := range tests {
+		resp := &http.Response{StatusCode: test.status, Header: http.Header{}}
+		if test.retryAfter != "" {
+			resp.Header.Set("Retry-After", test.retryAfter)
+		}
+		for i := 0; i < 20; i++ {
+			if delay := policy.delay(test.attempt, resp); delay < test.min || delay > test.max {
+				t.Errorf("attempt %d status %d Retry-After %q: delay %s, expected between %s and %s", test.attempt, test.status, test.retryAfter, delay, test.min, test.max)
+				break
+			}
+		}
+	}
+}
+
+func TestRetryPolicyWithDefaults(t *testing.T) {
+	tests := []struct {
+		policy   RetryPolicy
+		expected RetryPolicy
+	}{
+		{RetryPolicy{}, RetryPolicy{Attempts: DefaultRetryAttempts, MinDelay: DefaultRetryMinDelay, MaxDelay: DefaultRetryMaxDelay}},
+		{RetryPolicy{Attempts: 1}, RetryPolicy{Attempts: 1, MinDelay: DefaultRetryMinDelay, MaxDelay: DefaultRetryMaxDelay}},
+		{RetryPolicy{MinDelay: time.Minute}, RetryPolicy{Attempts: DefaultRetryAttempts, MinDelay: time.Minute, MaxDelay: time.Minute}},
+	}
+	for _, test := range tests {
+		if policy := test.policy.WithDefaults(); policy != test.expected {
+			t.Errorf("%+v: policy %+v, expected %+v", test.policy, policy, test.expected)
+		}
+	}
+}
+
+func TestRetryableRequest(t *testing.T) {
+	tests := []struct {
+		method    string
+		body      io.Reader
+		retryable bool
+	}{
+		{"GET", nil, true},
+		{"HEAD", nil, true},
+		{"PUT", strings.NewReader("manifest"), true},
+		{"PUT", io.MultiReader(strings.NewReader("layer")), false},
+		{"PATCH", strings.NewReader("chunk"), false},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, "https://registry.corp/v2/app/blobs/uploads/", test.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if retryableRequest(req) != test.retryable {
+			t.Errorf("%s with %T body: retryable %v, expected %v", test.method, test.body, !test.retryable, test.retryable)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package connection
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//systemCertsDir is the directory Docker daemon looks up registry certificates in
+const systemCertsDir = "/etc/docker/certs.d"
+
+//TLSConfig holds registry specific TLS settings
+type TLSConfig struct {
+	//Insecure disables certificate verification
+	Insecure bool
+	//CAFile is PEM bundle of certificate authorities trusted in addition to system roots
+	CAFile string
+	//CertFile and KeyFile hold client certificate used for mutual TLS
+	CertFile string
+	KeyFile  string
+}
+
+//tlsConfig builds TLS configuration for registry host. Besides explicitly configured files, certificates are loaded from
+//Docker style certs.d/<host>/ directories: *.crt files are trusted CAs, *.cert and *.key pairs are client certificates
+func tlsConfig(host string, config TLSConfig) (*tls.Config, error) {
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, errors.New("both client certificate and key have to be specified")
+	}
+	tc := &tls.Config{InsecureSkipVerify: config.Insecure}
+	caFiles := make([]string, 0)
+	certPairs := make([][2]string, 0)
+	for _, dir := range certsDirs(host) {
+		ca, pairs, err := readCertsDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		caFiles = append(caFiles, ca...)
+		certPairs = append(certPairs, pairs...)
+	}
+	if config.CAFile != "" {
+		caFiles = append(caFiles, config.CAFile)
+	}
+	if config.CertFile != "" {
+		certPairs = append(certPairs, [2]string{config.CertFile, config.KeyFile})
+	}
+	if len(caFiles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		for _, file := range caFiles {
+			pem, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read CA file: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, errors.New("CA file " + file + " does not contain PEM encoded certificates")
+			}
+			log.Printf("connection.tls registry=%s ca=%s", host, file)
+		}
+		tc.RootCAs = pool
+	}
+	for _, pair := range certPairs {
+		cert, err := tls.LoadX509KeyPair(pair[0], pair[1])
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate %s: %w", pair[0], err)
+		}
+		log.Printf("connection.tls registry=%s cert=%s", host, pair[0])
+		tc.Certificates = append(tc.Certificates, cert)
+	}
+	return tc, nil
+}
+
+//certsDirs lists existing certs.d directories of registry host
+func certsDirs(host string) []string {
+	roots := []string{systemCertsDir}
+	if dir := dockerConfigDir(); dir != "" {
+		roots = append(roots, filepath.Join(dir, "certs.d"))
+	}
+	dirs := make([]string, 0)
+	for _, root := range roots {
+		dir := filepath.Join(root, host)
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+//readCertsDir returns CA files and client certificate/key pairs of certs.d directory
+func readCertsDir(dir string) ([]string, [][2]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot read certificates directory: %w", err)
+	}
+	caFiles := make([]string, 0)
+	pairs := make([][2]string, 0)
+	for _, f := range files {
+		name := f.Name()
+		switch {
+		case strings.HasSuffix(name, ".crt"):
+			caFiles = append(caFiles, filepath.Join(dir, name))
+		case strings.HasSuffix(name, ".cert"):
+			key := strings.TrimSuffix(name, ".cert") + ".key"
+			if _, err := os.Stat(filepath.Join(dir, key)); err != nil {
+				return nil, nil, errors.New("missing key " + key + " for client certificate " + filepath.Join(dir, name))
+			}
+			pairs = append(pairs, [2]string{filepath.Join(dir, name), filepath.Join(dir, key)})
+		case strings.HasSuffix(name, ".key"):
+			cert := strings.TrimSuffix(name, ".key") + ".cert"
+			if _, err := os.Stat(filepath.Join(dir, cert)); err != nil {
+				return nil, nil, errors.New("missing client certificate " + cert + " for key " + filepath.Join(dir, name))
+			}
+		}
+	}
+	return caFiles, pairs, nil
+}
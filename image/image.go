@@ -1,130 +1,279 @@
 package image
 
 import (
-	"io/ioutil"
-	"log"
-
+	"context"
 	"fmt"
-	"os"
+	"io"
+	"io/ioutil"
 
+	"github.com/Jeffail/tunny"
+	"github.com/docker/distribution"
 	"github.com/docker/distribution/digest"
-	"github.com/docker/distribution/manifest"
-	manifestV1 "github.com/docker/distribution/manifest/schema1"
+	"github.com/docker/distribution/manifest/manifestlist"
 
 	"github.com/docker/libtrust"
 	"github.com/dustin/go-humanize"
+	"github.com/heroku/docker-registry-client/registry"
 	"github.com/vbaksa/promoter/connection"
 	"github.com/vbaksa/promoter/layer"
+	"github.com/vbaksa/promoter/manifest"
+	"github.com/vbaksa/promoter/report"
 
 	"gopkg.in/cheggaaa/pb.v1"
 )
 
 //Promote holds promotion structure used to hold promotion parameters
 type Promote struct {
-	SrcRegistry  string
-	SrcImage     string
-	SrcImageTag  string
-	SrcUsername  string
-	SrcPassword  string
-	SrcInsecure  bool
+	SrcRegistry string
+	SrcImage    string
+	SrcImageTag string
+	//SrcImageDigest pins source image to exact manifest digest. It takes precedence over SrcImageTag
+	SrcImageDigest digest.Digest
+	SrcUsername    string
+	SrcPassword    string
+	SrcInsecure    bool
+	//SrcCAFile, SrcCertFile and SrcKeyFile configure source registry TLS. Certificates in certs.d/<host> directories are used as well
+	SrcCAFile    string
+	SrcCertFile  string
+	SrcKeyFile   string
 	DestRegistry string
 	DestImage    string
 	DestImageTag string
 	DestUsername string
 	DestPassword string
 	DestInsecure bool
-	Debug        bool
+	//DestCAFile, DestCertFile and DestKeyFile configure destination registry TLS
+	DestCAFile   string
+	DestCertFile string
+	DestKeyFile  string
+	//SrcProxy and DestProxy override proxy environment variables, see connection.Config
+	SrcProxy  string
+	DestProxy string
+	//SrcMirrors lists pull mirrors tried before source registry e.g. mirror.corp for Docker Hub images
+	SrcMirrors []string
+	//Platforms limits image index promotion to specified platforms. All platforms are promoted when empty
+	Platforms []manifestlist.PlatformSpec
+	//InsecureRegistries lists registry hosts and CIDR networks which may be reached over plain HTTP when registry URL has no scheme
+	InsecureRegistries []string
+	//Transfer tunes layer transfer e.g. upload chunk size
+	Transfer layer.Options
+	//Retry configures retries of registry requests failing with transient errors
+	Retry connection.RetryPolicy
+	//Concurrency limits number of concurrent layer checks and transfers
+	Concurrency connection.Concurrency
+	//Output receives progress messages and progress bar, e.g. os.Stdout. Nothing is printed when nil
+	Output io.Writer
 }
 
-//PromoteImage is used to execute specified promotion structure
-func (pr *Promote) PromoteImage() {
-	if !pr.Debug {
-		log.SetOutput(ioutil.Discard)
+//PromoteImage is used to execute specified promotion structure. Returned result describes transferred blobs and pushed manifest
+func (pr *Promote) PromoteImage(ctx context.Context) (*report.Result, error) {
+	fmt.Fprintln(pr.out(), "Preparing Image Push")
+	srcHub, destHub, err := connection.InitConnection(pr.srcConfig(), pr.destConfig())
+	if err != nil {
+		return nil, err
+	}
+	srcReference := pr.SrcImageTag
+	if pr.SrcImageDigest != "" {
+		srcReference = pr.SrcImageDigest.String()
+		fmt.Fprintln(pr.out(), "Source image: "+pr.SrcImage+"@"+srcReference)
+	} else {
+		fmt.Fprintln(pr.out(), "Source image: "+pr.SrcImage+":"+srcReference)
 	}
-	fmt.Println("Preparing Image Push")
-	srcHub, destHub := connection.InitConnection(pr.SrcRegistry, pr.SrcUsername, pr.SrcPassword, pr.SrcInsecure, pr.DestRegistry, pr.DestUsername, pr.DestPassword, pr.DestInsecure)
-	fmt.Println("Source image: " + pr.SrcImage + ":" + pr.SrcImageTag)
-	fmt.Println("Destination image: " + pr.DestImage + ":" + pr.DestImageTag)
+	fmt.Fprintln(pr.out(), "Destination image: "+pr.DestImage+":"+pr.DestImageTag)
 
-	srcManifest, err := srcHub.Manifest(pr.SrcImage, pr.SrcImageTag)
+	//Pull mirrors are tried first, layers are then pulled from the registry which served the manifest
+	pullHubs := append(connection.ConnectMirrors(pr.srcConfig()), srcHub)
+	srcImage, pullHub, err := manifest.ResolveFrom(ctx, pullHubs, pr.SrcImage, srcReference, pr.Platforms)
 	if err != nil {
-		fmt.Println("Failed to download Source Image manifest. Error: " + err.Error())
-		os.Exit(1)
+		return nil, fmt.Errorf("failed to download source image %s manifest: %w", pr.SrcImage, err)
+	}
+	if pullHub != srcHub {
+		fmt.Fprintln(pr.out(), "Pulling from mirror "+pullHub.URL)
+	}
+	//Layers are pulled from registry which served the manifest, falling back to the other pull registries
+	blobHubs := layer.SourceHubs(pullHub, pullHubs)
+	srcManifest := srcImage.Manifest
+	fmt.Fprintln(pr.out(), "Source manifest: "+srcManifest.MediaType+" "+srcManifest.Digest.String())
+	for i, child := range srcImage.Children {
+		fmt.Fprintln(pr.out(), "Platform "+manifest.PlatformString(srcManifest.Manifests[i].Platform)+": "+child.Digest.String())
 	}
 
-	srcLayers := srcManifest.FSLayers
-	fmt.Println("Optimising upload...")
-	uploadLayer := layer.MissingLayers(destHub, pr.DestImage, srcLayers)
+	result := &report.Result{}
+	srcLayers := srcImage.Blobs()
+	concurrency := pr.Concurrency.WithDefaults()
+	limiter := connection.NewHostLimiter(concurrency)
+	fmt.Fprintln(pr.out(), "Optimising upload...")
+	uploadLayer, skipped := layer.MissingLayers(ctx, destHub, pr.DestImage, srcLayers, limiter.Workers(concurrency.Exists, destHub), limiter)
+	result.SkippedBlobs = skipped
+	var totalSaved int64
+	for _, d := range skipped {
+		fmt.Fprintln(pr.out(), "Layer already exists on Remote Registry: "+d.Digest)
+		totalSaved = totalSaved + d.Size
+	}
+	fmt.Fprintln(pr.out())
+	if totalSaved > 100 {
+		fmt.Fprintf(pr.out(), "Some layers already exist on Remote Registry. Skipping around %s of layer data. Total network bandwidth saved: %s \n", humanize.Bytes(uint64(totalSaved)), humanize.Bytes(uint64(totalSaved*2)))
+	}
+	fmt.Fprintln(pr.out())
+	var descriptors []distribution.Descriptor
+	if len(uploadLayer) > 0 {
+		descriptors, err = layer.MetadataFrom(ctx, blobHubs, pr.SrcImage, uploadLayer, limiter.Workers(concurrency.Metadata, pullHub), limiter)
+		if err != nil {
+			return result, err
+		}
+		mounted, remaining := layer.MountLayers(ctx, destHub, pr.DestImage, srcHub, pr.SrcImage, uploadLayer, limiter.Workers(concurrency.Exists, destHub), limiter)
+		uploadLayer = remaining
+		if len(mounted) > 0 {
+			fmt.Fprintf(pr.out(), "Mounted %d layers from %s without transferring layer data \n", len(mounted), pr.SrcImage)
+		}
+		isMounted := make(map[digest.Digest]bool)
+		for _, l := range mounted {
+			isMounted[l] = true
+		}
+		uploadDescriptors := make([]distribution.Descriptor, 0, len(remaining))
+		for _, d := range descriptors {
+			if isMounted[d.Digest] {
+				result.MountedBlobs = append(result.MountedBlobs, d)
+			} else {
+				uploadDescriptors = append(uploadDescriptors, d)
+			}
+		}
+		descriptors = uploadDescriptors
+	}
+	//Uploaded blobs are verified against sizes declared by the manifest rather than reported by source registry
+	sizes := srcImage.BlobSizes()
+	for i, d := range descriptors {
+		if size, ok := sizes[d.Digest]; ok {
+			descriptors[i].Size = size
+		}
+	}
 	if len(uploadLayer) > 0 {
-		totalDownloadSize := layer.DigestSize(srcHub, pr.SrcImage, uploadLayer)
-		fmt.Println()
-		fmt.Printf("Going to upload around %s of layer data. Expected network bandwidth: %s \n", humanize.Bytes(uint64(totalDownloadSize)), humanize.Bytes(uint64(totalDownloadSize*2)))
-		fmt.Println()
+		var totalDownloadSize int64
+		for _, d := range descriptors {
+			totalDownloadSize = totalDownloadSize + d.Size
+		}
+		fmt.Fprintln(pr.out())
+		fmt.Fprintf(pr.out(), "Going to upload around %s of layer data. Expected network bandwidth: %s \n", humanize.Bytes(uint64(totalDownloadSize)), humanize.Bytes(uint64(totalDownloadSize*2)))
+		fmt.Fprintln(pr.out())
 
-		fmt.Println()
-		fmt.Println("Uploading layers")
-		fmt.Println()
+		fmt.Fprintln(pr.out())
+		fmt.Fprintln(pr.out(), "Uploading layers")
+		fmt.Fprintln(pr.out())
 
-		done := make(chan bool)
+		done := make(chan error)
 		var totalReader = make(chan int64)
-		for _, l := range uploadLayer {
-			go func(l digest.Digest) {
-				layer.UploadLayerWithProgress(destHub, pr.DestImage, srcHub, pr.SrcImage, l, &totalReader)
-				done <- true
-			}(l)
+		uploadQueue := tunny.NewFunc(concurrency.Uploads, func(payload interface{}) interface{} {
+			d := payload.(distribution.Descriptor)
+			release, err := limiter.Acquire(ctx, append([]*registry.Registry{destHub}, blobHubs...)...)
+			if err != nil {
+				return err
+			}
+			defer release()
+			return layer.UploadLayerFrom(ctx, destHub, pr.DestImage, blobHubs, pr.SrcImage, d, &totalReader, pr.Transfer)
+		})
+		defer uploadQueue.Close()
+		for _, d := range descriptors {
+			go func(d distribution.Descriptor) {
+				err, _ := uploadQueue.Process(d).(error)
+				done <- err
+			}(d)
 		}
-		bar := pb.New64(totalDownloadSize * 2).SetUnits(pb.U_BYTES)
+		//Layer data is counted once, so the bar shows effective transfer rate
+		bar := pb.New64(totalDownloadSize).SetUnits(pb.U_BYTES)
+		bar.ShowSpeed = true
+		bar.Output = pr.out()
 		bar.Start()
 		go func() {
-			for {
-				t := <-totalReader
-				bar.Add64(t * 2)
+			for t := range totalReader {
+				bar.Add64(t)
 			}
 		}()
 
-		for i := 0; i < len(uploadLayer); i++ {
-			<-done
+		var uploadErr error
+		for i := 0; i < len(descriptors); i++ {
+			if err := <-done; err != nil {
+				uploadErr = err
+			}
 		}
+		close(totalReader)
 		bar.Finish()
+		if uploadErr != nil {
+			return result, uploadErr
+		}
+		result.CopiedBlobs = descriptors
 
-		fmt.Println("Finished uploading layers")
+		fmt.Fprintln(pr.out(), "Finished uploading layers")
 	}
-	fmt.Println("Generating Signing Key...")
-	key, err := libtrust.GenerateECP256PrivateKey()
-	if err != nil {
-		fmt.Println("Error occurred while generating Image Key")
-		fmt.Println("Error: " + err.Error())
-		os.Exit(1)
-	}
-	fmt.Println("Signing Image Manifest...")
 	destManifest := srcManifest
-	destManifest.Name = pr.DestImage
-	destManifest.Tag = pr.DestImageTag
-
-	manifest := &manifestV1.Manifest{
-		Versioned: manifest.Versioned{
-			SchemaVersion: 1,
-		},
-		Name:         pr.DestImage,
-		Tag:          pr.DestImageTag,
-		Architecture: destManifest.Architecture,
-		FSLayers:     destManifest.FSLayers,
-		History:      destManifest.History,
+	if srcManifest.Signed != nil {
+		fmt.Fprintln(pr.out(), "Generating Signing Key...")
+		key, err := libtrust.GenerateECP256PrivateKey()
+		if err != nil {
+			return result, fmt.Errorf("failed to generate image signing key: %w", err)
+		}
+		fmt.Fprintln(pr.out(), "Signing Image Manifest...")
+		destManifest, err = manifest.Sign(srcManifest, pr.DestImage, pr.DestImageTag, key)
+		if err != nil {
+			return result, fmt.Errorf("failed to sign image manifest: %w", err)
+		}
 	}
-	signedManifest, err := manifestV1.Sign(manifest, key)
+
+	fmt.Fprintln(pr.out(), "Submitting Image Manifest")
+	err = manifest.Push(ctx, destHub, pr.DestImage, pr.DestImageTag, &manifest.Image{Manifest: destManifest, Children: srcImage.Children})
 	if err != nil {
-		fmt.Println("Error occurred while Signing Image Manifest")
-		fmt.Println("Error: " + err.Error())
-		os.Exit(1)
+		return result, fmt.Errorf("failed to push image %s:%s manifest: %w", pr.DestImage, pr.DestImageTag, err)
 	}
+	result.Tags = append(result.Tags, report.Tag{
+		Image:        pr.DestImage,
+		Tag:          pr.DestImageTag,
+		SourceDigest: srcManifest.Digest,
+		Digest:       destManifest.Digest,
+	})
+	fmt.Fprintln(pr.out(), "Push Complete")
+	return result, nil
+}
 
-	fmt.Println("Submitting Image Manifest")
-	err = destHub.PutManifest(pr.DestImage, pr.DestImageTag, signedManifest)
+//out returns writer of progress messages
+func (pr *Promote) out() io.Writer {
+	if pr.Output == nil {
+		return ioutil.Discard
+	}
+	return pr.Output
+}
 
-	if err != nil {
-		fmt.Println("Manifest update error: " + err.Error())
-		os.Exit(1)
+func (pr *Promote) srcConfig() connection.Config {
+	return connection.Config{
+		URL:      pr.SrcRegistry,
+		Username: pr.SrcUsername,
+		Password: pr.SrcPassword,
+		TLS: connection.TLSConfig{
+			Insecure: pr.SrcInsecure,
+			CAFile:   pr.SrcCAFile,
+			CertFile: pr.SrcCertFile,
+			KeyFile:  pr.SrcKeyFile,
+		},
+		InsecureRegistries: pr.InsecureRegistries,
+		Proxy:              pr.SrcProxy,
+		Retry:              pr.Retry,
+		Mirrors:            pr.SrcMirrors,
+		Output:             pr.Output,
+	}
+}
+
+func (pr *Promote) destConfig() connection.Config {
+	return connection.Config{
+		URL:      pr.DestRegistry,
+		Username: pr.DestUsername,
+		Password: pr.DestPassword,
+		TLS: connection.TLSConfig{
+			Insecure: pr.DestInsecure,
+			CAFile:   pr.DestCAFile,
+			CertFile: pr.DestCertFile,
+			KeyFile:  pr.DestKeyFile,
+		},
+		InsecureRegistries: pr.InsecureRegistries,
+		Proxy:              pr.DestProxy,
+		Retry:              pr.Retry,
+		Output:             pr.Output,
 	}
-	fmt.Println("Push Complete")
-	os.Exit(0)
 }
@@ -0,0 +1,49 @@
+package layer
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/docker/distribution/digest"
+	"github.com/heroku/docker-registry-client/registry"
+)
+
+//BlobIndex remembers repositories blobs were promoted into. When several promotions of the same process share blobs,
+//blob transferred by one promotion is mounted by the others. Nil index remembers nothing
+type BlobIndex struct {
+	mu sync.Mutex
+	//repositories maps registry URL to blob repository
+	repositories map[string]map[digest.Digest]string
+}
+
+//NewBlobIndex creates empty blob index
+func NewBlobIndex() *BlobIndex {
+	return &BlobIndex{repositories: make(map[string]map[digest.Digest]string)}
+}
+
+//Add records that blobs exist in repository of the registry
+func (i *BlobIndex) Add(hub *registry.Registry, repository string, blobs ...digest.Digest) {
+	if i == nil {
+		return
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	key := strings.TrimSuffix(hub.URL, "/")
+	if i.repositories[key] == nil {
+		i.repositories[key] = make(map[digest.Digest]string)
+	}
+	for _, blob := range blobs {
+		i.repositories[key][blob] = repository
+	}
+}
+
+//Repository returns repository of the registry blob was promoted into
+func (i *BlobIndex) Repository(hub *registry.Registry, blob digest.Digest) (string, bool) {
+	if i == nil {
+		return "", false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	repository, ok := i.repositories[strings.TrimSuffix(hub.URL, "/")][blob]
+	return repository, ok
+}
@@ -1,14 +1,15 @@
 package layer
 
 import (
+	"context"
 	"fmt"
-	"os"
+	"io"
 
+	"github.com/Jeffail/tunny"
 	"github.com/docker/distribution"
 	"github.com/docker/distribution/digest"
-	manifestV1 "github.com/docker/distribution/manifest/schema1"
-	humanize "github.com/dustin/go-humanize"
 	"github.com/heroku/docker-registry-client/registry"
+	"github.com/vbaksa/promoter/connection"
 	"github.com/vbaksa/promoter/progressbar"
 )
 
@@ -24,48 +25,63 @@ type existingLayer struct {
 	Descriptor distribution.Descriptor
 }
 
-//MissingLayers computes list of layers required to be uploaded. Upload is optimized by skipping existing layers
-func MissingLayers(destHub *registry.Registry, destImage string, srcLayers []manifestV1.FSLayer) []digest.Digest {
+//MissingLayers computes list of layers required to be uploaded. Upload is optimized by skipping existing layers.
+//Layers which already exist in destination registry are returned as skipped. Number of concurrent checks is limited by workers
+//and by destination registry limit of limiter
+func MissingLayers(ctx context.Context, destHub *registry.Registry, destImage string, srcLayers []digest.Digest, workers int, limiter *connection.HostLimiter) (missing []digest.Digest, skipped []distribution.Descriptor) {
 
 	//Layers array returned by function
 	results := make([]digest.Digest, 0)
-	//How much space we will save by skipping already existing layers
-	var totalSaved int64
+	skipped = make([]distribution.Descriptor, 0)
 	//Temporary result channel
 	result := make(chan *layerCheckResult)
 
-	// check each layer on remote hub
+	//Layers can be shared between platforms of the same image, so check each of them only once
+	uniqueLayers := make([]digest.Digest, 0)
+	seen := make(map[digest.Digest]bool)
 	for _, layer := range srcLayers {
-		go func(layer manifestV1.FSLayer, result chan *layerCheckResult) {
-
-			layerMetada, err := destHub.LayerMetadata(destImage, layer.BlobSum)
-			if err != nil {
-				// Layer does not exist
-				//	fmt.Println("Layer does not exist: " + layer.BlobSum)
-				checkResult := &layerCheckResult{
-					Err: err,
-					Missing: &missingLayer{
-						Blob: layer.BlobSum,
-					},
-				}
-				result <- checkResult
-
-			} else {
-				// Layer exists
-				fmt.Println("Layer already exists on Remote Registry: " + layerMetada.Digest)
-				checkResult := &layerCheckResult{
-					Err: nil,
-					Exists: &existingLayer{
-						Descriptor: layerMetada,
-					},
-				}
-				result <- checkResult
+		if !seen[layer] {
+			seen[layer] = true
+			uniqueLayers = append(uniqueLayers, layer)
+		}
+	}
+
+	// check each layer on remote hub
+	queue := tunny.NewFunc(poolSize(workers), func(payload interface{}) interface{} {
+		layer := payload.(digest.Digest)
+		release, err := limiter.Acquire(ctx, destHub)
+		if err != nil {
+			return &layerCheckResult{Err: err, Missing: &missingLayer{Blob: layer}}
+		}
+		defer release()
+		layerMetada, err := destHub.LayerMetadata(destImage, layer)
+		if err != nil {
+			// Layer does not exist
+			//	fmt.Println("Layer does not exist: " + layer)
+			return &layerCheckResult{
+				Err: err,
+				Missing: &missingLayer{
+					Blob: layer,
+				},
 			}
-		}(layer, result)
+		}
+		// Layer exists
+		return &layerCheckResult{
+			Err: nil,
+			Exists: &existingLayer{
+				Descriptor: layerMetada,
+			},
+		}
+	})
+	defer queue.Close()
+	for _, layer := range uniqueLayers {
+		go func(layer digest.Digest) {
+			result <- queue.Process(layer).(*layerCheckResult)
+		}(layer)
 	}
 
 	// Wait for result (each layer check)
-	for i := 0; i < len(srcLayers); i++ {
+	for i := 0; i < len(uniqueLayers); i++ {
 		res := <-result
 		// If we got result about missing layer on remote registry, else check result about existing layer
 		if res.Missing != nil {
@@ -82,58 +98,119 @@ func MissingLayers(destHub *registry.Registry, destImage string, srcLayers []man
 			}
 
 		} else {
-			totalSaved = totalSaved + res.Exists.Descriptor.Size
+			skipped = append(skipped, res.Exists.Descriptor)
 		}
 	}
-	fmt.Println()
-	if totalSaved > 100 {
-		fmt.Printf("Some layers already exist on Remote Registry. Skipping around %s of layer data. Total network bandwidth saved: %s \n", humanize.Bytes(uint64(totalSaved)), humanize.Bytes(uint64(totalSaved*2)))
+	return results, skipped
+}
+
+//poolSize returns number of pool workers, at least one
+func poolSize(workers int) int {
+	if workers < 1 {
+		return 1
 	}
-	fmt.Println()
+	return workers
+}
 
-	return results
+type metadataResult struct {
+	descriptor distribution.Descriptor
+	err        error
 }
 
-//DigestSize returns total upload size
-func DigestSize(srcHub *registry.Registry, srcImage string, uploadLayer []digest.Digest) int64 {
-	result := make(chan distribution.Descriptor)
-	for _, layer := range uploadLayer {
+//Metadata retrieves size of each specified layer. Number of concurrent requests is limited by workers
+func Metadata(srcHub *registry.Registry, srcImage string, layers []digest.Digest, workers int) ([]distribution.Descriptor, error) {
+	return MetadataFrom(context.Background(), []*registry.Registry{srcHub}, srcImage, layers, workers, nil)
+}
+
+//MetadataFrom retrieves size of each specified layer from the first registry which has it, see BlobMetadata.
+//Requests hold slots of all source registries of limiter
+func MetadataFrom(ctx context.Context, srcHubs []*registry.Registry, srcImage string, layers []digest.Digest, workers int, limiter *connection.HostLimiter) ([]distribution.Descriptor, error) {
+	result := make(chan *metadataResult)
+	queue := tunny.NewFunc(poolSize(workers), func(payload interface{}) interface{} {
+		layer := payload.(digest.Digest)
+		release, err := limiter.Acquire(ctx, srcHubs...)
+		if err != nil {
+			return &metadataResult{err: err}
+		}
+		defer release()
+		l, err := BlobMetadata(srcHubs, srcImage, layer)
+		return &metadataResult{descriptor: l, err: err}
+	})
+	defer queue.Close()
+	for _, layer := range layers {
 		go func(layer digest.Digest) {
-			l, err := srcHub.LayerMetadata(srcImage, layer)
-			if err != nil {
-				fmt.Println("Error while inspecting layer: " + layer)
-				fmt.Println("Error: " + err.Error())
-				os.Exit(1)
-			}
-			result <- l
+			result <- queue.Process(layer).(*metadataResult)
 		}(layer)
 	}
-	var total int64
-	for i := 0; i < len(uploadLayer); i++ {
+	descriptors := make([]distribution.Descriptor, 0, len(layers))
+	var err error
+	for i := 0; i < len(layers); i++ {
 		r := <-result
-		total = total + r.Size
+		if r.err != nil {
+			err = r.err
+			continue
+		}
+		descriptors = append(descriptors, r.descriptor)
+	}
+	if err != nil {
+		return nil, err
 	}
-	return total
+	return descriptors, nil
 }
 
-//UploadLayer uploads image layer with option to track upload progress
-func UploadLayer(destHub *registry.Registry, destImage string, srcHub *registry.Registry, srcImage string, layer digest.Digest) {
-	UploadLayerWithProgress(destHub, destImage, srcHub, srcImage, layer, nil)
+//DigestSize returns total upload size
+func DigestSize(srcHub *registry.Registry, srcImage string, uploadLayer []digest.Digest, workers int) (int64, error) {
+	descriptors, err := Metadata(srcHub, srcImage, uploadLayer, workers)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, d := range descriptors {
+		total = total + d.Size
+	}
+	return total, nil
 }
 
-//UploadLayerWithProgress uploads image layer with option to track upload progress
-func UploadLayerWithProgress(destHub *registry.Registry, destImage string, srcHub *registry.Registry, srcImage string, layer digest.Digest, totalReader *chan int64) {
-	reader, err := srcHub.DownloadLayer(srcImage, layer)
-	defer reader.Close()
-	if totalReader != nil {
-		rd := &progressbar.PassThru{ReadCloser: reader, Total: totalReader}
-		destHub.UploadLayer(destImage, layer, rd)
+//UploadLayer uploads image layer. Layer data is verified against blob digest and size (when known) while it is streamed
+func UploadLayer(ctx context.Context, destHub *registry.Registry, destImage string, srcHub *registry.Registry, srcImage string, blob distribution.Descriptor, opts Options) error {
+	return UploadLayerWithProgress(ctx, destHub, destImage, srcHub, srcImage, blob, nil, opts)
+}
+
+//UploadLayerWithProgress uploads image layer with option to track upload progress. Upload is aborted with *VerificationError
+//when layer data does not match blob digest or size. Transfer rate is limited by opts.DownloadLimit and opts.UploadLimit
+func UploadLayerWithProgress(ctx context.Context, destHub *registry.Registry, destImage string, srcHub *registry.Registry, srcImage string, blob distribution.Descriptor, totalReader *chan int64, opts Options) error {
+	return UploadLayerFrom(ctx, destHub, destImage, []*registry.Registry{srcHub}, srcImage, blob, totalReader, opts)
+}
+
+//UploadLayerFrom uploads image layer pulled from the first of source registries which has it. Download continues
+//from the next registry when registry does not have the layer or can not be reached, see UploadLayerWithProgress
+func UploadLayerFrom(ctx context.Context, destHub *registry.Registry, destImage string, srcHubs []*registry.Registry, srcImage string, blob distribution.Descriptor, totalReader *chan int64, opts Options) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	src := newBlobReader(ctx, srcHubs, srcImage, blob, opts.DownloadLimit)
+	defer src.Close()
+	var rd io.ReadCloser = src
+	var err error
+	if opts.ChunkSize > 0 {
+		var progress func(int64)
+		if totalReader != nil {
+			progress = func(n int64) {
+				*totalReader <- n
+			}
+		}
+		err = uploadChunked(ctx, destHub, destImage, blob.Digest, src, rd, opts.ChunkSize, opts.UploadLimit, progress)
 	} else {
-		destHub.UploadLayer(destImage, layer, reader)
+		if totalReader != nil {
+			rd = &progressbar.PassThru{ReadCloser: rd, Total: totalReader}
+		}
+		err = uploadMonolithic(ctx, destHub, destImage, blob.Digest, limitReader(ctx, rd, opts.UploadLimit))
+	}
+	if src.verifyErr != nil {
+		return src.verifyErr
 	}
 	if err != nil {
-		fmt.Println("Error occurred while uploading layer: " + layer)
-		fmt.Println("Error: " + err.Error())
-		os.Exit(1)
+		return fmt.Errorf("failed to upload layer %s: %w", blob.Digest, err)
 	}
+	return nil
 }
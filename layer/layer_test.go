@@ -0,0 +1,73 @@
+package layer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/digest"
+	"github.com/heroku/docker-registry-client/registry"
+	"github.com/vbaksa/promoter/connection"
+)
+
+//concurrencyRegistry answers blob HEAD requests slowly and records the highest number of requests served at once
+type concurrencyRegistry struct {
+	mu       sync.Mutex
+	inFlight int
+	max      int
+}
+
+func (c *concurrencyRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	c.inFlight++
+	if c.inFlight > c.max {
+		c.max = c.inFlight
+	}
+	c.mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	c.mu.Lock()
+	c.inFlight--
+	c.mu.Unlock()
+	w.Header().Set("Content-Length", "1024")
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestLayerRequestsLimited(t *testing.T) {
+	layers := make([]digest.Digest, 8)
+	for i := range layers {
+		layers[i] = digest.FromBytes([]byte(fmt.Sprint(i)))
+	}
+	tests := []struct {
+		name  string
+		limit int
+		//request checks layers using hub, limited by limiter
+		request func(hub *registry.Registry, limiter *connection.HostLimiter)
+	}{
+		{"existence checks", 1, func(hub *registry.Registry, limiter *connection.HostLimiter) {
+			MissingLayers(context.Background(), hub, "app", layers, 4, limiter)
+		}},
+		{"existence checks", 2, func(hub *registry.Registry, limiter *connection.HostLimiter) {
+			MissingLayers(context.Background(), hub, "app", layers, 4, limiter)
+		}},
+		{"metadata", 1, func(hub *registry.Registry, limiter *connection.HostLimiter) {
+			MetadataFrom(context.Background(), []*registry.Registry{hub}, "app", layers, 4, limiter)
+		}},
+		{"metadata", 2, func(hub *registry.Registry, limiter *connection.HostLimiter) {
+			MetadataFrom(context.Background(), []*registry.Registry{hub}, "app", layers, 4, limiter)
+		}},
+	}
+	for _, test := range tests {
+		c := &concurrencyRegistry{}
+		server := httptest.NewServer(c)
+		hub := &registry.Registry{URL: server.URL, Client: server.Client(), Logf: registry.Quiet}
+		test.request(hub, connection.NewHostLimiter(connection.Concurrency{MaxPerRegistry: test.limit}))
+		server.Close()
+		if c.max > test.limit {
+			t.Errorf("%s: %d concurrent requests, expected at most %d", test.name, c.max, test.limit)
+		}
+	}
+}
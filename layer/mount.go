@@ -0,0 +1,107 @@
+package layer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Jeffail/tunny"
+	"github.com/docker/distribution/digest"
+	"github.com/heroku/docker-registry-client/registry"
+	"github.com/vbaksa/promoter/connection"
+)
+
+type mountResult struct {
+	layer   digest.Digest
+	mounted bool
+}
+
+//CanMount reports whether layers can be mounted instead of streamed, which is possible only within the same registry
+func CanMount(srcHub *registry.Registry, destHub *registry.Registry) bool {
+	return strings.TrimSuffix(srcHub.URL, "/") == strings.TrimSuffix(destHub.URL, "/")
+}
+
+//MountLayers mounts layers from source repository when both repositories live in the same registry.
+//Layers which registry refused to mount (e.g. credentials do not allow pulling from source repository) are returned as remaining.
+//Number of concurrent mount requests is limited by workers and by destination registry limit of limiter
+func MountLayers(ctx context.Context, destHub *registry.Registry, destImage string, srcHub *registry.Registry, srcImage string, layers []digest.Digest, workers int, limiter *connection.HostLimiter) (mounted []digest.Digest, remaining []digest.Digest) {
+	mounted = make([]digest.Digest, 0)
+	remaining = make([]digest.Digest, 0)
+	if !CanMount(srcHub, destHub) {
+		return mounted, append(remaining, layers...)
+	}
+	queue := tunny.NewFunc(poolSize(workers), func(payload interface{}) interface{} {
+		layer := payload.(digest.Digest)
+		release, err := limiter.Acquire(ctx, destHub)
+		if err != nil {
+			return &mountResult{layer: layer}
+		}
+		defer release()
+		ok, err := MountLayer(ctx, destHub, destImage, srcImage, layer)
+		if err != nil {
+			destHub.Logf("layer.mount failed layer=%s error=%s", layer, err.Error())
+		}
+		return &mountResult{layer: layer, mounted: ok}
+	})
+	defer queue.Close()
+	result := make(chan *mountResult)
+	for _, layer := range layers {
+		go func(layer digest.Digest) {
+			result <- queue.Process(layer).(*mountResult)
+		}(layer)
+	}
+	for i := 0; i < len(layers); i++ {
+		res := <-result
+		if res.mounted {
+			mounted = append(mounted, res.layer)
+		} else {
+			remaining = append(remaining, res.layer)
+		}
+	}
+	return mounted, remaining
+}
+
+//MountLayer asks registry to mount layer from another repository. Registry responds with 201 when layer is mounted
+//and starts regular upload session (202) when mount is not possible. Such session is cancelled straight away
+func MountLayer(ctx context.Context, hub *registry.Registry, destImage string, srcImage string, layer digest.Digest) (bool, error) {
+	q := url.Values{}
+	q.Set("mount", layer.String())
+	q.Set("from", srcImage)
+	mountURL := fmt.Sprintf("%s/v2/%s/blobs/uploads/?%s", hub.URL, destImage, q.Encode())
+	hub.Logf("registry.layer.mount url=%s repository=%s from=%s digest=%s", mountURL, destImage, srcImage, layer)
+
+	req, err := http.NewRequest("POST", mountURL, nil)
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	resp, err := hub.Client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return true, nil
+	case http.StatusAccepted:
+		cancelUpload(ctx, hub, resp.Header.Get("Location"))
+	}
+	return false, nil
+}
+
+//cancelUpload deletes upload session, so it does not linger in registry until it expires
+func cancelUpload(ctx context.Context, hub *registry.Registry, location string) {
+	if location == "" {
+		return
+	}
+	req, err := http.NewRequest("DELETE", absoluteLocation(hub, location), nil)
+	if err != nil {
+		return
+	}
+	resp, err := hub.Client.Do(req.WithContext(ctx))
+	if resp != nil {
+		resp.Body.Close()
+	}
+}
@@ -0,0 +1,88 @@
+package layer
+
+import (
+	"context"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+//minBurst is the smallest amount of data rate limiter lets through without waiting
+const minBurst = 32 * 1024
+
+//RateLimiter is token bucket limiting combined rate of all transfers sharing it. Nil limiter does not limit anything
+type RateLimiter struct {
+	//rate is number of bytes per second, burst is size of the bucket
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+//NewRateLimiter creates limiter of specified number of bytes per second. Nil limiter is returned when rate is zero
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	burst := bytesPerSecond / 10
+	if burst < minBurst {
+		burst = minBurst
+	}
+	return &RateLimiter{rate: float64(bytesPerSecond), burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+//Wait takes n bytes from the bucket, waiting until they are available. Transfers waiting together are served
+//in order they asked, so the bucket is shared fairly
+func (l *RateLimiter) Wait(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	//Tokens are reserved straight away, so bucket can go into debt which the following transfers wait for
+	l.tokens = l.tokens - float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//rateReader limits rate data is read from underlying reader at
+type rateReader struct {
+	ctx   context.Context
+	rd    io.Reader
+	limit *RateLimiter
+}
+
+func (r *rateReader) Read(p []byte) (int, error) {
+	n, err := r.rd.Read(p)
+	if waitErr := r.limit.Wait(r.ctx, n); waitErr != nil {
+		return n, waitErr
+	}
+	return n, err
+}
+
+//limitReader returns reader limited by rate limiter, or rd itself when there is no limit
+func limitReader(ctx context.Context, rd io.Reader, limit *RateLimiter) io.Reader {
+	if limit == nil {
+		return rd
+	}
+	return &rateReader{ctx: ctx, rd: rd, limit: limit}
+}
@@ -0,0 +1,68 @@
+package layer
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterWait(t *testing.T) {
+	tests := []struct {
+		name string
+		rate int64
+		//waits are numbers of bytes taken from the bucket one after another
+		waits []int
+		//tokens is expected bucket balance afterwards, negative when the bucket is in debt
+		tokens float64
+	}{
+		{"within burst", 1000000, []int{50000, 50000}, 0},
+		{"burst at least 32KiB", 1000, []int{minBurst}, 0},
+		{"debt of single read", 1000000, []int{150000}, -50000},
+		{"debt accumulates", 1000000, []int{100000, 20000, 30000}, -50000},
+		{"nothing taken", 1000000, []int{0, -1}, 100000},
+	}
+	for _, test := range tests {
+		l := NewRateLimiter(test.rate)
+		//Bucket is not refilled during the test
+		l.rate = 1e-9
+		for _, n := range test.waits {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			l.Wait(ctx, n)
+		}
+		if math.Abs(l.tokens-test.tokens) > 1 {
+			t.Errorf("%s: bucket holds %.0f tokens, expected %.0f", test.name, l.tokens, test.tokens)
+		}
+	}
+}
+
+func TestRateLimiterDelay(t *testing.T) {
+	l := NewRateLimiter(100000)
+	//Burst of 32KiB passes straight away, the rest waits for refill
+	start := time.Now()
+	if err := l.Wait(context.Background(), minBurst+10000); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond || elapsed > time.Second {
+		t.Errorf("waited %s for 10000 bytes at 100000 B/s, expected about 100ms", elapsed)
+	}
+	//Debt of the previous transfer is paid by the next one
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx, 20000); err != context.DeadlineExceeded {
+		t.Errorf("error %v, expected %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestNilRateLimiter(t *testing.T) {
+	for _, rate := range []int64{0, -1} {
+		if l := NewRateLimiter(rate); l != nil {
+			t.Errorf("%d: expected nil limiter", rate)
+		}
+	}
+	var l *RateLimiter
+	if err := l.Wait(context.Background(), 1<<30); err != nil {
+		t.Errorf("nil limiter: unexpected error: %v", err)
+	}
+}
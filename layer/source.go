@@ -0,0 +1,55 @@
+package layer
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
+	"github.com/heroku/docker-registry-client/registry"
+)
+
+//SourceHubs orders registries blobs are pulled from: registry which served the manifest first, followed by the other
+//registries in their order, typically remaining pull mirrors and source registry
+func SourceHubs(first *registry.Registry, hubs []*registry.Registry) []*registry.Registry {
+	ordered := make([]*registry.Registry, 0, len(hubs)+1)
+	ordered = append(ordered, first)
+	for _, hub := range hubs {
+		if hub != nil && hub != first {
+			ordered = append(ordered, hub)
+		}
+	}
+	return ordered
+}
+
+//BlobMetadata reads blob metadata from the first registry which has it. Next registry is tried when registry does not
+//have the blob or can not be reached
+func BlobMetadata(hubs []*registry.Registry, repository string, blob digest.Digest) (distribution.Descriptor, error) {
+	err := errors.New("no registry to read layer " + blob.String() + " from")
+	for i, hub := range hubs {
+		var d distribution.Descriptor
+		d, err = hub.LayerMetadata(repository, blob)
+		if err == nil {
+			return d, nil
+		}
+		if !fallback(err) {
+			break
+		}
+		if i < len(hubs)-1 {
+			hub.Logf("layer.metadata.fallback url=%s repository=%s digest=%s error=%s", hub.URL, repository, blob, err.Error())
+		}
+	}
+	return distribution.Descriptor{}, fmt.Errorf("failed to inspect layer %s: %w", blob, err)
+}
+
+//fallback reports whether blob should be pulled from the next registry: registry does not have the blob or connection failed
+func fallback(err error) bool {
+	var statusErr *registry.HttpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Response.StatusCode == http.StatusNotFound
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
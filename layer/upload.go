@@ -0,0 +1,361 @@
+package layer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
+	"github.com/heroku/docker-registry-client/registry"
+)
+
+//DefaultChunkSize is size of single upload request used by command line tools. Every upload worker buffers one chunk
+const DefaultChunkSize = 8 * 1024 * 1024
+
+//maxResumeAttempts limits how many times single layer transfer is resumed after transient failure
+const maxResumeAttempts = 5
+
+//Options tune layer transfer. Zero value uploads each layer in single request
+type Options struct {
+	//ChunkSize is size of single PATCH request. Layer is uploaded in single request when zero
+	ChunkSize int64
+	//DownloadLimit and UploadLimit limit transfer rate of layer data. Limiters are shared by all transfers using them
+	DownloadLimit *RateLimiter
+	UploadLimit   *RateLimiter
+}
+
+//uploadChunked uploads layer as sequence of PATCH requests. When chunk upload fails, registry is asked how much data it
+//received and upload continues from that offset. Source download is restarted at the same offset when data is no longer buffered.
+//Progress is reported from offsets registry has received, so data read again after restart is not counted twice
+func uploadChunked(ctx context.Context, hub *registry.Registry, repository string, layer digest.Digest, src *blobReader, rd io.Reader, chunkSize int64, limit *RateLimiter, progress func(int64)) error {
+	location, err := initiateUpload(ctx, hub, repository)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, chunkSize)
+	var offset, reported int64
+	attempts := 0
+	for {
+		n, readErr := io.ReadFull(rd, buf)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			cancelUpload(ctx, hub, location)
+			return readErr
+		}
+		chunkStart := offset
+		chunk := buf[:n]
+		reread := false
+		for len(chunk) > 0 {
+			next, err := patchChunk(ctx, hub, location, offset, chunk, limit)
+			if err == nil {
+				location = next
+				offset = offset + int64(len(chunk))
+				if offset > reported && progress != nil {
+					progress(offset - reported)
+					reported = offset
+				}
+				break
+			}
+			if attempts >= maxResumeAttempts || ctx.Err() != nil {
+				cancelUpload(ctx, hub, location)
+				return err
+			}
+			attempts++
+			next, received, statusErr := uploadStatus(ctx, hub, location, offset)
+			if statusErr != nil {
+				cancelUpload(ctx, hub, location)
+				return fmt.Errorf("%s. Upload could not be resumed: %w", err.Error(), statusErr)
+			}
+			hub.Logf("layer.upload.resume digest=%s offset=%d attempt=%d error=%s", layer, received, attempts, err.Error())
+			location = next
+			offset = received
+			if received < chunkStart || received > chunkStart+int64(n) {
+				//Data is no longer buffered, so source is downloaded again starting at registry offset
+				src.restart(received)
+				reread = true
+				break
+			}
+			chunk = buf[received-chunkStart : n]
+		}
+		if !reread && readErr != nil {
+			break
+		}
+	}
+	return completeUpload(ctx, hub, location, layer)
+}
+
+//uploadMonolithic uploads whole layer in single PUT request. Upload session is cancelled when transfer fails
+func uploadMonolithic(ctx context.Context, hub *registry.Registry, repository string, layer digest.Digest, rd io.Reader) error {
+	location, err := initiateUpload(ctx, hub, repository)
+	if err != nil {
+		return err
+	}
+	uploadURL, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	q := uploadURL.Query()
+	q.Set("digest", layer.String())
+	uploadURL.RawQuery = q.Encode()
+	hub.Logf("registry.layer.upload url=%s repository=%s digest=%s", uploadURL, repository, layer)
+	req, err := http.NewRequest("PUT", uploadURL.String(), rd)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := hub.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		cancelUpload(ctx, hub, location)
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+//initiateUpload starts upload session and returns its location
+func initiateUpload(ctx context.Context, hub *registry.Registry, repository string) (string, error) {
+	initiateURL := fmt.Sprintf("%s/v2/%s/blobs/uploads/", hub.URL, repository)
+	hub.Logf("registry.layer.initiate-upload url=%s repository=%s", initiateURL, repository)
+	req, err := http.NewRequest("POST", initiateURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := hub.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", errors.New("registry did not return upload location")
+	}
+	return absoluteLocation(hub, location), nil
+}
+
+//patchChunk uploads single chunk starting at specified offset and returns location of the next request. Chunk is sent
+//no faster than rate limit allows, including repeated sends
+func patchChunk(ctx context.Context, hub *registry.Registry, location string, offset int64, chunk []byte, limit *RateLimiter) (string, error) {
+	hub.Logf("registry.layer.upload-chunk url=%s range=%d-%d", location, offset, offset+int64(len(chunk))-1)
+	req, err := http.NewRequest("PATCH", location, bytes.NewReader(chunk))
+	if err != nil {
+		return "", err
+	}
+	if limit != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(limitReader(ctx, bytes.NewReader(chunk), limit)), nil
+		}
+		req.Body, _ = req.GetBody()
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+int64(len(chunk))-1))
+	resp, err := hub.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if received, ok := parseRange(resp.Header.Get("Range")); ok && received != offset+int64(len(chunk)) {
+		return "", fmt.Errorf("registry received %d bytes, expected %d", received, offset+int64(len(chunk)))
+	}
+	if next := resp.Header.Get("Location"); next != "" {
+		return absoluteLocation(hub, next), nil
+	}
+	return location, nil
+}
+
+//uploadStatus asks registry how many bytes of the upload session it has received. Acknowledged is number of bytes registry
+//confirmed earlier, because registries report empty upload session as 0-0, the same as session holding single byte
+func uploadStatus(ctx context.Context, hub *registry.Registry, location string, acknowledged int64) (string, int64, error) {
+	hub.Logf("registry.layer.upload-status url=%s", location)
+	req, err := http.NewRequest("GET", location, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	resp, err := hub.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", 0, err
+	}
+	resp.Body.Close()
+	received, _ := parseRange(resp.Header.Get("Range"))
+	if received == 1 && acknowledged == 0 {
+		received = 0
+	}
+	if next := resp.Header.Get("Location"); next != "" {
+		location = absoluteLocation(hub, next)
+	}
+	return location, received, nil
+}
+
+//completeUpload finishes upload session. Registry verifies uploaded data against layer digest
+func completeUpload(ctx context.Context, hub *registry.Registry, location string, layer digest.Digest) error {
+	uploadURL, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	q := uploadURL.Query()
+	q.Set("digest", layer.String())
+	uploadURL.RawQuery = q.Encode()
+	hub.Logf("registry.layer.upload-complete url=%s digest=%s", uploadURL, layer)
+	req, err := http.NewRequest("PUT", uploadURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := hub.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+//parseRange parses Range header of upload session e.g. 0-1023 and returns number of received bytes
+func parseRange(header string) (int64, bool) {
+	header = strings.TrimPrefix(header, "bytes=")
+	s := strings.SplitN(header, "-", 2)
+	if len(s) != 2 {
+		return 0, false
+	}
+	end, err := strconv.ParseInt(s[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return end + 1, true
+}
+
+//absoluteLocation resolves upload location returned by registry, which can be relative
+func absoluteLocation(hub *registry.Registry, location string) string {
+	if strings.HasPrefix(location, "http") {
+		return location
+	}
+	return hub.URL + location
+}
+
+//blobReader downloads blob from source registry and verifies its digest and size. Download is restarted with HTTP Range request
+//from the current offset when connection breaks, and stops as soon as context is cancelled. Next of source registries is used
+//when registry does not have the blob or can not be reached
+type blobReader struct {
+	ctx        context.Context
+	hubs       []*registry.Registry
+	hub        *registry.Registry
+	repository string
+	digest     digest.Digest
+	offset     int64
+	attempts   int
+	body       io.ReadCloser
+	limit      *RateLimiter
+	verifier   *blobVerifier
+	//verifyErr is kept, so verification failure is reported even when upload client replaces it with its own error
+	verifyErr error
+}
+
+func newBlobReader(ctx context.Context, hubs []*registry.Registry, repository string, blob distribution.Descriptor, limit *RateLimiter) *blobReader {
+	return &blobReader{
+		ctx:        ctx,
+		hubs:       hubs[1:],
+		hub:        hubs[0],
+		repository: repository,
+		digest:     blob.Digest,
+		limit:      limit,
+		verifier:   newBlobVerifier(repository, blob),
+	}
+}
+
+func (r *blobReader) Read(p []byte) (int, error) {
+	if r.verifyErr != nil {
+		return 0, r.verifyErr
+	}
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if r.body == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.body.Read(p)
+	if waitErr := r.limit.Wait(r.ctx, n); waitErr != nil {
+		return 0, waitErr
+	}
+	if verifyErr := r.verifier.write(r.offset, p[:n]); verifyErr != nil {
+		r.verifyErr = verifyErr
+		return 0, verifyErr
+	}
+	r.offset = r.offset + int64(n)
+	if err == io.EOF {
+		if verifyErr := r.verifier.verify(); verifyErr != nil {
+			r.verifyErr = verifyErr
+			return n, verifyErr
+		}
+	}
+	if err != nil && err != io.EOF && r.attempts < maxResumeAttempts && r.ctx.Err() == nil {
+		r.attempts++
+		r.hub.Logf("layer.download.resume digest=%s offset=%d attempt=%d error=%s", r.digest, r.offset, r.attempts, err.Error())
+		r.body.Close()
+		r.body = nil
+		return n, nil
+	}
+	return n, err
+}
+
+//restart restarts download at specified offset
+func (r *blobReader) restart(offset int64) {
+	if r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	r.offset = offset
+}
+
+//open starts download from the current registry, switching to the next registry when download can not be started
+func (r *blobReader) open() error {
+	for {
+		err := r.openHub()
+		if err == nil || len(r.hubs) == 0 || !fallback(err) || r.ctx.Err() != nil {
+			return err
+		}
+		r.hub.Logf("layer.download.fallback url=%s repository=%s digest=%s error=%s", r.hub.URL, r.repository, r.digest, err.Error())
+		r.hub, r.hubs = r.hubs[0], r.hubs[1:]
+	}
+}
+
+func (r *blobReader) openHub() error {
+	downloadURL := fmt.Sprintf("%s/v2/%s/blobs/%s", r.hub.URL, r.repository, r.digest)
+	r.hub.Logf("registry.layer.download url=%s repository=%s digest=%s offset=%d", downloadURL, r.repository, r.digest, r.offset)
+	req, err := http.NewRequest("GET", downloadURL, nil)
+	if err != nil {
+		return err
+	}
+	if r.offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", r.offset))
+	}
+	resp, err := r.hub.Client.Do(req.WithContext(r.ctx))
+	if err != nil {
+		return fmt.Errorf("failed to download layer %s: %w", r.digest, err)
+	}
+	//Registry ignored Range request, so already transferred data is skipped
+	if r.offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, r.offset); err != nil {
+			resp.Body.Close()
+			return fmt.Errorf("failed to download layer %s: %w", r.digest, err)
+		}
+	}
+	r.body = resp.Body
+	return nil
+}
+
+//Close closes current download
+func (r *blobReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
@@ -0,0 +1,97 @@
+package layer
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/distribution/digest"
+	"github.com/heroku/docker-registry-client/registry"
+)
+
+//uploadRegistry is fake registry upload endpoint which drops connection of selected PATCH requests
+type uploadRegistry struct {
+	mu       sync.Mutex
+	data     []byte
+	patches  int
+	failures map[int]bool
+	digest   string
+}
+
+func (u *uploadRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	switch r.Method {
+	case "POST":
+		w.Header().Set("Location", "/v2/app/blobs/uploads/session")
+		w.WriteHeader(http.StatusAccepted)
+	case "PATCH":
+		u.patches++
+		if u.failures[u.patches] {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if expected := fmt.Sprintf("%d-%d", len(u.data), len(u.data)+len(body)-1); r.Header.Get("Content-Range") != expected {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		u.data = append(u.data, body...)
+		u.writeRange(w)
+		w.WriteHeader(http.StatusAccepted)
+	case "GET":
+		u.writeRange(w)
+		w.WriteHeader(http.StatusNoContent)
+	case "PUT":
+		u.digest = r.URL.Query().Get("digest")
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+//writeRange reports received data the way registries do, empty session as 0-0
+func (u *uploadRegistry) writeRange(w http.ResponseWriter) {
+	end := len(u.data) - 1
+	if end < 0 {
+		end = 0
+	}
+	w.Header().Set("Location", "/v2/app/blobs/uploads/session")
+	w.Header().Set("Range", fmt.Sprintf("0-%d", end))
+}
+
+func TestUploadChunkedResume(t *testing.T) {
+	blob := []byte("0123456789")
+	tests := []struct {
+		name     string
+		failures map[int]bool
+	}{
+		{"no failure", nil},
+		{"first chunk", map[int]bool{1: true}},
+		{"first chunk twice", map[int]bool{1: true, 2: true}},
+		{"middle chunk", map[int]bool{2: true}},
+		{"last chunk", map[int]bool{3: true}},
+	}
+	for _, test := range tests {
+		u := &uploadRegistry{failures: test.failures}
+		server := httptest.NewServer(u)
+		hub := &registry.Registry{URL: server.URL, Client: server.Client(), Logf: registry.Quiet}
+		var progress int64
+		err := uploadChunked(context.Background(), hub, "app", digest.FromBytes(blob), nil, strings.NewReader(string(blob)), 4, nil, func(n int64) { progress += n })
+		server.Close()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if string(u.data) != string(blob) || u.digest != digest.FromBytes(blob).String() {
+			t.Errorf("%s: registry received %q with digest %s, expected %q", test.name, u.data, u.digest, blob)
+		}
+		if progress != int64(len(blob)) {
+			t.Errorf("%s: progress %d, expected %d", test.name, progress, len(blob))
+		}
+	}
+}
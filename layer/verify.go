@@ -0,0 +1,73 @@
+package layer
+
+import (
+	"fmt"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
+)
+
+//VerificationError is returned when downloaded blob does not match digest or size expected by the image manifest.
+//Upload of such blob is aborted before registry completes it
+type VerificationError struct {
+	//Image is source repository blob was downloaded from
+	Image string
+	//Expected describes blob referenced by the manifest. Size is zero when manifest does not declare it
+	Expected distribution.Descriptor
+	//Digest and Size describe data actually received from source registry
+	Digest digest.Digest
+	Size   int64
+}
+
+func (e *VerificationError) Error() string {
+	if e.Expected.Size > 0 && e.Size != e.Expected.Size {
+		return fmt.Sprintf("blob %s of image %s failed verification: expected size %d, received %d bytes", e.Expected.Digest, e.Image, e.Expected.Size, e.Size)
+	}
+	return fmt.Sprintf("blob %s of image %s failed verification: received data has digest %s", e.Expected.Digest, e.Image, e.Digest)
+}
+
+//blobVerifier computes digest of blob while it is streamed. Data re-read after download restart is hashed only once
+type blobVerifier struct {
+	image    string
+	expected distribution.Descriptor
+	digester digest.Digester
+	hashed   int64
+}
+
+func newBlobVerifier(image string, expected distribution.Descriptor) *blobVerifier {
+	v := &blobVerifier{image: image, expected: expected}
+	if expected.Digest.Algorithm().Available() {
+		v.digester = expected.Digest.Algorithm().New()
+	}
+	return v
+}
+
+//write hashes data read at specified offset of the blob
+func (v *blobVerifier) write(offset int64, p []byte) error {
+	end := offset + int64(len(p))
+	if end > v.hashed {
+		skip := v.hashed - offset
+		if skip < 0 {
+			skip = 0
+		}
+		if v.digester != nil {
+			v.digester.Hash().Write(p[skip:])
+		}
+		v.hashed = end
+	}
+	if v.expected.Size > 0 && v.hashed > v.expected.Size {
+		return &VerificationError{Image: v.image, Expected: v.expected, Size: v.hashed}
+	}
+	return nil
+}
+
+//verify compares complete blob with expected digest and size
+func (v *blobVerifier) verify() error {
+	if v.expected.Size > 0 && v.hashed != v.expected.Size {
+		return &VerificationError{Image: v.image, Expected: v.expected, Size: v.hashed}
+	}
+	if v.digester != nil && v.digester.Digest() != v.expected.Digest {
+		return &VerificationError{Image: v.image, Expected: v.expected, Digest: v.digester.Digest(), Size: v.hashed}
+	}
+	return nil
+}
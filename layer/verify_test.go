@@ -0,0 +1,65 @@
+package layer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
+)
+
+//chunk is data read at offset of the blob
+type chunk struct {
+	offset int64
+	data   string
+}
+
+func TestBlobVerifier(t *testing.T) {
+	blob := "0123456789"
+	expected := distribution.Descriptor{Digest: digest.FromBytes([]byte(blob)), Size: int64(len(blob))}
+	unsized := distribution.Descriptor{Digest: expected.Digest}
+	tests := []struct {
+		name     string
+		expected distribution.Descriptor
+		reads    []chunk
+		writeErr bool
+		valid    bool
+	}{
+		{"single read", expected, []chunk{{0, blob}}, false, true},
+		{"sequential reads", expected, []chunk{{0, "0123"}, {4, "456"}, {7, "789"}}, false, true},
+		{"restart and re-read", expected, []chunk{{0, "012345"}, {2, "2345678"}, {9, "9"}}, false, true},
+		{"restart from start", expected, []chunk{{0, "01234"}, {0, blob}}, false, true},
+		{"unknown size", unsized, []chunk{{0, "01234"}, {3, "3456789"}}, false, true},
+		{"truncated", expected, []chunk{{0, "012345678"}}, false, false},
+		{"size overflow", expected, []chunk{{0, blob}, {10, "X"}}, true, false},
+		{"digest mismatch", expected, []chunk{{0, "01234X6789"}}, false, false},
+		{"digest mismatch of unknown size", unsized, []chunk{{0, "012345678"}}, false, false},
+	}
+	for _, test := range tests {
+		v := newBlobVerifier("app", test.expected)
+		var err error
+		for _, read := range test.reads {
+			if err = v.write(read.offset, []byte(read.data)); err != nil {
+				break
+			}
+		}
+		if (err != nil) != test.writeErr {
+			t.Errorf("%s: write error %v, expected error %v", test.name, err, test.writeErr)
+			continue
+		}
+		if err == nil {
+			err = v.verify()
+		}
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if !test.valid {
+			var verifyErr *VerificationError
+			if !errors.As(err, &verifyErr) {
+				t.Errorf("%s: expected *VerificationError, got %v", test.name, err)
+			} else if verifyErr.Expected.Digest != test.expected.Digest || verifyErr.Image != "app" {
+				t.Errorf("%s: error describes blob %s of image %s", test.name, verifyErr.Expected.Digest, verifyErr.Image)
+			}
+		}
+	}
+}
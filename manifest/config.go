@@ -0,0 +1,73 @@
+package manifest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/heroku/docker-registry-client/registry"
+)
+
+//maxConfigSize limits image configuration download, configurations are typically few kilobytes
+const maxConfigSize = 4 << 20
+
+//imageConfig covers fields of Docker and OCI image configuration used by promoter
+type imageConfig struct {
+	Created time.Time `json:"created"`
+}
+
+//Created returns image creation time recorded in image configuration. Newest platform image is used for image indexes
+func Created(ctx context.Context, hub *registry.Registry, repository string, img *Image) (time.Time, error) {
+	manifests := []*Manifest{img.Manifest}
+	if img.Manifest.IsIndex() {
+		manifests = img.Children
+	}
+	var created time.Time
+	for _, m := range manifests {
+		t, err := m.created(ctx, hub, repository)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if t.After(created) {
+			created = t
+		}
+	}
+	if created.IsZero() {
+		return created, errors.New("image configuration does not record creation time")
+	}
+	return created, nil
+}
+
+func (m *Manifest) created(ctx context.Context, hub *registry.Registry, repository string) (time.Time, error) {
+	config := &imageConfig{}
+	//Schema1 manifests embed configuration of the top layer as v1 compatibility history
+	if m.Signed != nil {
+		if len(m.Signed.History) == 0 {
+			return time.Time{}, nil
+		}
+		err := json.Unmarshal([]byte(m.Signed.History[0].V1Compatibility), config)
+		return config.Created, err
+	}
+	if m.Config == nil {
+		return time.Time{}, nil
+	}
+	url := fmt.Sprintf("%s/v2/%s/blobs/%s", hub.URL, repository, m.Config.Digest)
+	hub.Logf("registry.config.get url=%s repository=%s digest=%s", url, repository, m.Config.Digest)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	resp, err := hub.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxConfigSize)).Decode(config); err != nil {
+		return time.Time{}, fmt.Errorf("cannot parse image configuration %s: %w", m.Config.Digest, err)
+	}
+	return config.Created, nil
+}
@@ -0,0 +1,98 @@
+package manifest
+
+import (
+	"context"
+	"errors"
+
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/heroku/docker-registry-client/registry"
+)
+
+//Image holds top level manifest and, for image indexes, all child manifests which have to be promoted
+type Image struct {
+	Manifest *Manifest
+	Children []*Manifest
+}
+
+//Resolve downloads image manifest. Image index is filtered by platforms (all platforms when empty) and its child manifests are downloaded
+func Resolve(ctx context.Context, hub *registry.Registry, repository string, reference string, platforms []manifestlist.PlatformSpec) (*Image, error) {
+	m, err := Get(ctx, hub, repository, reference)
+	if err != nil {
+		return nil, err
+	}
+	if !m.IsIndex() {
+		return &Image{Manifest: m}, nil
+	}
+	m, err = Filter(m, platforms)
+	if err != nil {
+		return nil, err
+	}
+	img := &Image{
+		Manifest: m,
+		Children: make([]*Manifest, 0, len(m.Manifests)),
+	}
+	for _, d := range m.Manifests {
+		child, err := Get(ctx, hub, repository, d.Digest.String())
+		if err != nil {
+			return nil, err
+		}
+		if child.IsIndex() {
+			return nil, errors.New("nested image indexes are not supported: " + d.Digest.String())
+		}
+		img.Children = append(img.Children, child)
+	}
+	return img, nil
+}
+
+//ResolveFrom resolves image from the first registry which has it. Registries are tried in order, typically pull mirrors
+//followed by upstream registry. Registry image was resolved from is returned together with the image
+func ResolveFrom(ctx context.Context, hubs []*registry.Registry, repository string, reference string, platforms []manifestlist.PlatformSpec) (*Image, *registry.Registry, error) {
+	err := errors.New("no registry to resolve image from")
+	for i, hub := range hubs {
+		var img *Image
+		img, err = Resolve(ctx, hub, repository, reference, platforms)
+		if err == nil {
+			return img, hub, nil
+		}
+		if i < len(hubs)-1 {
+			hub.Logf("manifest.resolve.fallback url=%s repository=%s reference=%s error=%s", hub.URL, repository, reference, err.Error())
+		}
+	}
+	return nil, nil, err
+}
+
+//Blobs returns blobs referenced by the image and all its child manifests. Blobs shared between platforms are listed multiple times
+func (img *Image) Blobs() []digest.Digest {
+	blobs := img.Manifest.Blobs()
+	for _, child := range img.Children {
+		blobs = append(blobs, child.Blobs()...)
+	}
+	return blobs
+}
+
+//BlobSizes returns blob sizes declared by the image manifests. Schema1 manifests do not declare sizes, so their blobs are not listed
+func (img *Image) BlobSizes() map[digest.Digest]int64 {
+	sizes := make(map[digest.Digest]int64)
+	for _, m := range append([]*Manifest{img.Manifest}, img.Children...) {
+		if m.Config != nil && m.Config.Size > 0 {
+			sizes[m.Config.Digest] = m.Config.Size
+		}
+		for _, l := range m.Layers {
+			if l.Size > 0 {
+				sizes[l.Digest] = l.Size
+			}
+		}
+	}
+	return sizes
+}
+
+//Push uploads child manifests by digest followed by top level manifest under specified reference
+func Push(ctx context.Context, hub *registry.Registry, repository string, reference string, img *Image) error {
+	for _, child := range img.Children {
+		if err := Put(ctx, hub, repository, child.Digest.String(), child); err != nil {
+			return err
+		}
+	}
+	return Put(ctx, hub, repository, reference, img.Manifest)
+}
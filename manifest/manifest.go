@@ -0,0 +1,293 @@
+package manifest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/docker/distribution/digest"
+	dockerManifest "github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/manifest/manifestlist"
+	manifestV1 "github.com/docker/distribution/manifest/schema1"
+	manifestV2 "github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/libtrust"
+	"github.com/heroku/docker-registry-client/registry"
+)
+
+const (
+	//MediaTypeOCIManifest specifies the mediaType for OCI image manifest
+	MediaTypeOCIManifest = "application/vnd.oci.image.manifest.v1+json"
+	//MediaTypeOCIIndex specifies the mediaType for OCI image index
+	MediaTypeOCIIndex = "application/vnd.oci.image.index.v1+json"
+	//MediaTypeOCIConfig specifies the mediaType for OCI image configuration
+	MediaTypeOCIConfig = "application/vnd.oci.image.config.v1+json"
+
+	//mediaTypeOCINondistributable prefixes OCI layers which must not be pushed to registries
+	mediaTypeOCINondistributable = "application/vnd.oci.image.layer.nondistributable."
+)
+
+//Descriptor references content addressable blob. It covers both Docker and OCI descriptor fields
+type Descriptor struct {
+	MediaType   string                     `json:"mediaType,omitempty"`
+	Size        int64                      `json:"size,omitempty"`
+	Digest      digest.Digest              `json:"digest,omitempty"`
+	URLs        []string                   `json:"urls,omitempty"`
+	Annotations map[string]string          `json:"annotations,omitempty"`
+	Platform    *manifestlist.PlatformSpec `json:"platform,omitempty"`
+}
+
+//Manifest holds image manifest exactly as it was served by the registry together with the blobs it references
+type Manifest struct {
+	MediaType   string
+	Digest      digest.Digest
+	Payload     []byte
+	Config      *Descriptor
+	Layers      []Descriptor
+	Manifests   []Descriptor
+	Annotations map[string]string
+	//Signed is populated only for schema1 manifests, which have to be re-signed when name or tag changes
+	Signed *manifestV1.SignedManifest
+}
+
+//document covers fields of Docker schema2, OCI manifest and OCI index documents
+type document struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Config        *Descriptor       `json:"config,omitempty"`
+	Layers        []Descriptor      `json:"layers,omitempty"`
+	Manifests     []Descriptor      `json:"manifests,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+//media types requested from the registry in order of preference
+var acceptedMediaTypes = []string{
+	MediaTypeOCIIndex,
+	manifestlist.MediaTypeManifestList,
+	MediaTypeOCIManifest,
+	manifestV2.MediaTypeManifest,
+	manifestV1.MediaTypeSignedManifest,
+	manifestV1.MediaTypeManifest,
+}
+
+//Get downloads image manifest by tag or digest. Image indexes are preferred over OCI and schema2 manifests, schema1 is returned only when registry has nothing better
+func Get(ctx context.Context, hub *registry.Registry, repository string, reference string) (*Manifest, error) {
+	url := fmt.Sprintf("%s/v2/%s/manifests/%s", hub.URL, repository, reference)
+	hub.Logf("registry.manifest.get url=%s repository=%s reference=%s", url, repository, reference)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for _, mediaType := range acceptedMediaTypes {
+		req.Header.Add("Accept", mediaType)
+	}
+	resp, err := hub.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	m, err := Parse(resp.Header.Get("Content-Type"), body)
+	if err != nil {
+		return nil, err
+	}
+	//Manifest requested by digest must match it exactly
+	if dgst, err := digest.ParseDigest(reference); err == nil && m.Digest != dgst {
+		return nil, errors.New("manifest digest mismatch. Expected: " + dgst.String() + " Received: " + m.Digest.String())
+	}
+	return m, nil
+}
+
+//Head returns digest of manifest reported by registry in Docker-Content-Digest header. Empty digest is returned
+//when manifest does not exist or registry does not report digest
+func Head(ctx context.Context, hub *registry.Registry, repository string, reference string) (digest.Digest, error) {
+	url := fmt.Sprintf("%s/v2/%s/manifests/%s", hub.URL, repository, reference)
+	hub.Logf("registry.manifest.head url=%s repository=%s reference=%s", url, repository, reference)
+
+	req, err := http.NewRequest("HEAD", url, nil)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	for _, mediaType := range acceptedMediaTypes {
+		req.Header.Add("Accept", mediaType)
+	}
+	resp, err := hub.Client.Do(req)
+	var statusErr *registry.HttpStatusError
+	if errors.As(err, &statusErr) && statusErr.Response.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	header := resp.Header.Get("Docker-Content-Digest")
+	if header == "" {
+		return "", nil
+	}
+	return digest.ParseDigest(header)
+}
+
+//Parse decodes manifest payload of the specified content type
+func Parse(contentType string, payload []byte) (*Manifest, error) {
+	mediaType := detectMediaType(contentType, payload)
+	m := &Manifest{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(payload),
+		Payload:   payload,
+	}
+	switch mediaType {
+	case manifestV2.MediaTypeManifest, MediaTypeOCIManifest:
+		var doc document
+		if err := json.Unmarshal(payload, &doc); err != nil {
+			return nil, err
+		}
+		if doc.Config == nil {
+			return nil, errors.New("manifest does not reference image configuration")
+		}
+		m.Config = doc.Config
+		m.Layers = doc.Layers
+		m.Annotations = doc.Annotations
+	case MediaTypeOCIIndex, manifestlist.MediaTypeManifestList:
+		var doc document
+		if err := json.Unmarshal(payload, &doc); err != nil {
+			return nil, err
+		}
+		m.Manifests = doc.Manifests
+		m.Annotations = doc.Annotations
+	case manifestV1.MediaTypeSignedManifest:
+		signed := &manifestV1.SignedManifest{}
+		if err := signed.UnmarshalJSON(payload); err != nil {
+			return nil, err
+		}
+		m.Signed = signed
+		m.Digest = digest.FromBytes(signed.Canonical)
+		for _, l := range signed.FSLayers {
+			m.Layers = append(m.Layers, Descriptor{
+				MediaType: manifestV1.MediaTypeManifestLayer,
+				Digest:    l.BlobSum,
+			})
+		}
+	default:
+		return nil, errors.New("unsupported manifest media type: " + mediaType)
+	}
+	return m, nil
+}
+
+//IsIndex reports whether manifest references other manifests instead of blobs
+func (m *Manifest) IsIndex() bool {
+	return m.MediaType == MediaTypeOCIIndex || m.MediaType == manifestlist.MediaTypeManifestList
+}
+
+//Blobs returns all blobs referenced by manifest: config blob followed by layers.
+//Non-distributable layers are skipped, because registries serve them from external URLs
+func (m *Manifest) Blobs() []digest.Digest {
+	blobs := make([]digest.Digest, 0, len(m.Layers)+1)
+	if m.Config != nil {
+		blobs = append(blobs, m.Config.Digest)
+	}
+	for _, l := range m.Layers {
+		if l.IsForeign() {
+			continue
+		}
+		blobs = append(blobs, l.Digest)
+	}
+	return blobs
+}
+
+//IsForeign reports whether layer is non-distributable and has to be downloaded from its URLs
+func (d Descriptor) IsForeign() bool {
+	if len(d.URLs) == 0 {
+		return false
+	}
+	return d.MediaType == manifestV2.MediaTypeForeignLayer || strings.HasPrefix(d.MediaType, mediaTypeOCINondistributable)
+}
+
+//Sign rebuilds schema1 manifest for the new repository name and tag. Other manifest types are returned unchanged
+func Sign(m *Manifest, name string, tag string, key libtrust.PrivateKey) (*Manifest, error) {
+	if m.Signed == nil {
+		return m, nil
+	}
+	unsigned := &manifestV1.Manifest{
+		Versioned: dockerManifest.Versioned{
+			SchemaVersion: 1,
+		},
+		Name:         name,
+		Tag:          tag,
+		Architecture: m.Signed.Architecture,
+		FSLayers:     m.Signed.FSLayers,
+		History:      m.Signed.History,
+	}
+	signed, err := manifestV1.Sign(unsigned, key)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := signed.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	return &Manifest{
+		MediaType: m.MediaType,
+		Digest:    digest.FromBytes(signed.Canonical),
+		Payload:   payload,
+		Layers:    m.Layers,
+		Signed:    signed,
+	}, nil
+}
+
+//Put uploads manifest payload byte-for-byte, so destination digest matches the source one
+func Put(ctx context.Context, hub *registry.Registry, repository string, reference string, m *Manifest) error {
+	url := fmt.Sprintf("%s/v2/%s/manifests/%s", hub.URL, repository, reference)
+	hub.Logf("registry.manifest.put url=%s repository=%s reference=%s", url, repository, reference)
+
+	req, err := http.NewRequest("PUT", url, bytes.NewReader(m.Payload))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", m.MediaType)
+	resp, err := hub.Client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	return err
+}
+
+//detectMediaType relies on Content-Type header and inspects payload when registry returns generic type
+func detectMediaType(contentType string, payload []byte) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil {
+		switch mediaType {
+		case manifestV2.MediaTypeManifest, manifestV1.MediaTypeSignedManifest, MediaTypeOCIManifest, MediaTypeOCIIndex, manifestlist.MediaTypeManifestList:
+			return mediaType
+		case manifestV1.MediaTypeManifest:
+			return manifestV1.MediaTypeSignedManifest
+		}
+	}
+	var doc document
+	if err := json.Unmarshal(payload, &doc); err != nil {
+		return mediaType
+	}
+	switch {
+	case doc.MediaType != "":
+		return doc.MediaType
+	case doc.SchemaVersion == 1:
+		return manifestV1.MediaTypeSignedManifest
+	case doc.Manifests != nil:
+		//OCI documents are not required to declare mediaType
+		return MediaTypeOCIIndex
+	case doc.Config != nil:
+		return MediaTypeOCIManifest
+	}
+	return mediaType
+}
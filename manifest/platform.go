@@ -0,0 +1,99 @@
+package manifest
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/docker/distribution/manifest/manifestlist"
+)
+
+//ParsePlatforms parses comma separated platform list e.g. linux/amd64,linux/arm64/v8
+func ParsePlatforms(platforms string) ([]manifestlist.PlatformSpec, error) {
+	result := make([]manifestlist.PlatformSpec, 0)
+	for _, platform := range strings.Split(platforms, ",") {
+		platform = strings.TrimSpace(platform)
+		if platform == "" {
+			continue
+		}
+		s := strings.Split(platform, "/")
+		if len(s) < 2 || len(s) > 3 || s[0] == "" || s[1] == "" {
+			return nil, errors.New("invalid platform " + platform + ". Platform format should be following: os/architecture[/variant] e.g. linux/arm64")
+		}
+		spec := manifestlist.PlatformSpec{
+			OS:           s[0],
+			Architecture: s[1],
+		}
+		if len(s) == 3 {
+			spec.Variant = s[2]
+		}
+		result = append(result, spec)
+	}
+	return result, nil
+}
+
+//PlatformString formats platform the same way it is accepted by ParsePlatforms
+func PlatformString(platform *manifestlist.PlatformSpec) string {
+	if platform == nil {
+		return "unknown"
+	}
+	s := platform.OS + "/" + platform.Architecture
+	if platform.Variant != "" {
+		s = s + "/" + platform.Variant
+	}
+	return s
+}
+
+//matchPlatform checks manifest platform against requested ones. Variant is compared only when requested
+func matchPlatform(platform *manifestlist.PlatformSpec, platforms []manifestlist.PlatformSpec) bool {
+	if platform == nil {
+		return false
+	}
+	for _, p := range platforms {
+		if p.OS == platform.OS && p.Architecture == platform.Architecture && (p.Variant == "" || p.Variant == platform.Variant) {
+			return true
+		}
+	}
+	return false
+}
+
+//Filter returns image index referencing only manifests for specified platforms.
+//Index is returned unchanged when all its manifests match, so the digest is preserved
+func Filter(index *Manifest, platforms []manifestlist.PlatformSpec) (*Manifest, error) {
+	if !index.IsIndex() || len(platforms) == 0 {
+		return index, nil
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(index.Payload, &doc); err != nil {
+		return nil, err
+	}
+	var rawManifests []json.RawMessage
+	if err := json.Unmarshal(doc["manifests"], &rawManifests); err != nil {
+		return nil, err
+	}
+	if len(rawManifests) != len(index.Manifests) {
+		return nil, errors.New("image index manifests could not be decoded")
+	}
+	kept := make([]json.RawMessage, 0)
+	for i, m := range index.Manifests {
+		if matchPlatform(m.Platform, platforms) {
+			kept = append(kept, rawManifests[i])
+		}
+	}
+	if len(kept) == 0 {
+		return nil, errors.New("image index does not contain manifests for requested platforms")
+	}
+	if len(kept) == len(rawManifests) {
+		return index, nil
+	}
+	filtered, err := json.Marshal(kept)
+	if err != nil {
+		return nil, err
+	}
+	doc["manifests"] = filtered
+	payload, err := json.MarshalIndent(doc, "", "   ")
+	if err != nil {
+		return nil, err
+	}
+	return Parse(index.MediaType, payload)
+}
@@ -0,0 +1,212 @@
+package reference
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/docker/distribution/digest"
+)
+
+const (
+	//DockerHub is registry name used for references without registry
+	DockerHub = "docker.io"
+	//DockerHubEndpoint is Docker Hub registry API host
+	DockerHubEndpoint = "registry-1.docker.io"
+	//DefaultTag is used when reference specifies neither tag nor digest
+	DefaultTag = "latest"
+
+	//official Docker Hub images live in library namespace
+	officialNamespace = "library"
+	maxNameLength     = 255
+)
+
+//dockerHubAliases are registry names which refer to Docker Hub
+var dockerHubAliases = map[string]bool{
+	DockerHub:            true,
+	"index.docker.io":    true,
+	DockerHubEndpoint:    true,
+	"hub.docker.io":      true, //kept for backward compatibility with earlier promoter releases
+	"registry.docker.io": true,
+}
+
+var (
+	pathComponentRegexp = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*$`)
+	registryRegexp      = regexp.MustCompile(`^(?:(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])(?:\.(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9]))*|\[[a-fA-F0-9:.]+\])(?::[0-9]+)?$`)
+	tagRegexp           = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+)
+
+//Reference holds parsed image reference: registry, repository path and optional tag or digest
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     digest.Digest
+}
+
+//Parse parses image reference e.g. ubuntu:16.04, myregistry:5000/team/sub/app:1.0 or [::1]:5000/app@sha256:...
+//References without registry point to Docker Hub. Single component Docker Hub repositories are placed into library namespace
+func Parse(s string) (*Reference, error) {
+	if s == "" {
+		return nil, errors.New("invalid image reference: reference is empty")
+	}
+	ref := &Reference{}
+	name := s
+	if i := strings.Index(name, "@"); i >= 0 {
+		dgst, err := digest.ParseDigest(name[i+1:])
+		if err != nil {
+			return nil, errors.New("invalid image reference " + s + ". Digest error: " + err.Error())
+		}
+		ref.Digest = dgst
+		name = name[:i]
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") && !strings.HasSuffix(name, "]") {
+		ref.Tag = name[i+1:]
+		name = name[:i]
+		if !tagRegexp.MatchString(ref.Tag) {
+			return nil, errors.New("invalid image reference " + s + ". Invalid tag: " + ref.Tag)
+		}
+	}
+	if err := ref.setName(name); err != nil {
+		return nil, errors.New("invalid image reference " + s + ". " + err.Error())
+	}
+	return ref, nil
+}
+
+//ParseRepository parses image reference which must not contain tag or digest
+func ParseRepository(s string) (*Reference, error) {
+	ref, err := Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if ref.Tag != "" || ref.Digest != "" {
+		return nil, errors.New("invalid image reference " + s + ". Image tag or digest must not be specified")
+	}
+	return ref, nil
+}
+
+//ParseNamespace parses registry namespace e.g. myregistry:5000/team or myregistry:5000 for the whole registry.
+//Unlike image references, namespace must start with registry host. Namespace is stored as Repository and can be empty
+func ParseNamespace(s string) (*Reference, error) {
+	name := strings.TrimSuffix(s, "/")
+	parts := strings.SplitN(name, "/", 2)
+	if !isRegistry(parts[0]) || !registryRegexp.MatchString(parts[0]) {
+		return nil, errors.New("invalid namespace " + s + ". Namespace has to start with registry host e.g. myregistry:5000/team")
+	}
+	ref := &Reference{Registry: parts[0]}
+	if IsDockerHub(ref.Registry) {
+		ref.Registry = DockerHub
+	}
+	if len(parts) == 2 {
+		ref.Repository = parts[1]
+		for _, component := range strings.Split(ref.Repository, "/") {
+			if !pathComponentRegexp.MatchString(component) {
+				return nil, errors.New("invalid namespace " + s + ". Invalid namespace name: " + ref.Repository)
+			}
+		}
+	}
+	return ref, nil
+}
+
+//setName splits name into registry and repository path and validates both
+func (ref *Reference) setName(name string) error {
+	s := strings.SplitN(name, "/", 2)
+	if len(s) == 2 && isRegistry(s[0]) {
+		ref.Registry = s[0]
+		ref.Repository = s[1]
+	} else {
+		ref.Registry = DockerHub
+		ref.Repository = name
+	}
+	if !registryRegexp.MatchString(ref.Registry) {
+		return errors.New("Invalid registry: " + ref.Registry)
+	}
+	if IsDockerHub(ref.Registry) {
+		ref.Registry = DockerHub
+		if !strings.Contains(ref.Repository, "/") {
+			ref.Repository = officialNamespace + "/" + ref.Repository
+		}
+	}
+	if len(ref.Repository) > maxNameLength {
+		return errors.New("Repository name is too long")
+	}
+	for _, component := range strings.Split(ref.Repository, "/") {
+		if !pathComponentRegexp.MatchString(component) {
+			return errors.New("Invalid repository name: " + ref.Repository)
+		}
+	}
+	return nil
+}
+
+//IsDockerHub reports whether registry name or host refers to Docker Hub
+func IsDockerHub(registry string) bool {
+	return dockerHubAliases[strings.ToLower(registry)]
+}
+
+//IsValidRepository reports whether repository path without registry is valid e.g. team/app
+func IsValidRepository(repository string) bool {
+	if len(repository) > maxNameLength {
+		return false
+	}
+	for _, component := range strings.Split(repository, "/") {
+		if !pathComponentRegexp.MatchString(component) {
+			return false
+		}
+	}
+	return true
+}
+
+//IsValidTag reports whether tag is valid image tag
+func IsValidTag(tag string) bool {
+	return tagRegexp.MatchString(tag)
+}
+
+//isRegistry reports whether first name component is a registry host rather than repository namespace
+func isRegistry(component string) bool {
+	return strings.ContainsAny(component, ".:[") || component == "localhost"
+}
+
+//LegacyRegistry returns first name component of Docker Hub reference which earlier promoter releases treated as registry host,
+//e.g. myregistry for myregistry/team/app. Empty string is returned when reference is parsed the same way as before
+func LegacyRegistry(s string) string {
+	ref, err := Parse(s)
+	if err != nil || ref.Registry != DockerHub {
+		return ""
+	}
+	parts := strings.Split(strings.SplitN(s, "@", 2)[0], "/")
+	if len(parts) < 3 || IsDockerHub(parts[0]) {
+		return ""
+	}
+	return parts[0]
+}
+
+//Endpoint returns registry host used for API calls
+func (ref *Reference) Endpoint() string {
+	if ref.Registry == DockerHub {
+		return DockerHubEndpoint
+	}
+	return ref.Registry
+}
+
+//Reference returns manifest reference: digest when specified, otherwise tag or default tag
+func (ref *Reference) Reference() string {
+	if ref.Digest != "" {
+		return ref.Digest.String()
+	}
+	if ref.Tag != "" {
+		return ref.Tag
+	}
+	return DefaultTag
+}
+
+//String returns normalized image reference
+func (ref *Reference) String() string {
+	s := ref.Registry + "/" + ref.Repository
+	if ref.Tag != "" {
+		s = s + ":" + ref.Tag
+	}
+	if ref.Digest != "" {
+		s = s + "@" + ref.Digest.String()
+	}
+	return s
+}
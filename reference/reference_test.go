@@ -0,0 +1,175 @@
+package reference
+
+import (
+	"testing"
+)
+
+const testDigest = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input      string
+		registry   string
+		repository string
+		tag        string
+		digest     string
+		reference  string
+		endpoint   string
+	}{
+		{"ubuntu", "docker.io", "library/ubuntu", "", "", "latest", "registry-1.docker.io"},
+		{"ubuntu:16.04", "docker.io", "library/ubuntu", "16.04", "", "16.04", "registry-1.docker.io"},
+		{"docker.io/nginx", "docker.io", "library/nginx", "", "", "latest", "registry-1.docker.io"},
+		{"index.docker.io/library/nginx:1.13", "docker.io", "library/nginx", "1.13", "", "1.13", "registry-1.docker.io"},
+		{"hub.docker.io/library/ubuntu:18.04", "docker.io", "library/ubuntu", "18.04", "", "18.04", "registry-1.docker.io"},
+		{"myuser/app", "docker.io", "myuser/app", "", "", "latest", "registry-1.docker.io"},
+		{"notdocker.io/ns/app", "notdocker.io", "ns/app", "", "", "latest", "notdocker.io"},
+		{"localhost/app", "localhost", "app", "", "", "latest", "localhost"},
+		{"localhost:5000/library/ubuntu:16.04", "localhost:5000", "library/ubuntu", "16.04", "", "16.04", "localhost:5000"},
+		{"host:5000/ns/app", "host:5000", "ns/app", "", "", "latest", "host:5000"},
+		{"registry.corp/team/sub/app:1.4.2", "registry.corp", "team/sub/app", "1.4.2", "", "1.4.2", "registry.corp"},
+		{"registry/ns/app@" + testDigest, "docker.io", "registry/ns/app", "", testDigest, testDigest, "registry-1.docker.io"},
+		{"registry.corp:443/ns/app:1.0@" + testDigest, "registry.corp:443", "ns/app", "1.0", testDigest, testDigest, "registry.corp:443"},
+		{"[::1]/app", "[::1]", "app", "", "", "latest", "[::1]"},
+		{"[2001:db8::1]:5000/ns/app:v1", "[2001:db8::1]:5000", "ns/app", "v1", "", "v1", "[2001:db8::1]:5000"},
+		{"host/a.b/c__d/e-f:Tag_1", "docker.io", "host/a.b/c__d/e-f", "Tag_1", "", "Tag_1", "registry-1.docker.io"},
+	}
+	for _, test := range tests {
+		ref, err := Parse(test.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.input, err)
+			continue
+		}
+		if ref.Registry != test.registry {
+			t.Errorf("%s: registry %q, expected %q", test.input, ref.Registry, test.registry)
+		}
+		if ref.Repository != test.repository {
+			t.Errorf("%s: repository %q, expected %q", test.input, ref.Repository, test.repository)
+		}
+		if ref.Tag != test.tag {
+			t.Errorf("%s: tag %q, expected %q", test.input, ref.Tag, test.tag)
+		}
+		if ref.Digest.String() != test.digest {
+			t.Errorf("%s: digest %q, expected %q", test.input, ref.Digest, test.digest)
+		}
+		if ref.Reference() != test.reference {
+			t.Errorf("%s: reference %q, expected %q", test.input, ref.Reference(), test.reference)
+		}
+		if ref.Endpoint() != test.endpoint {
+			t.Errorf("%s: endpoint %q, expected %q", test.input, ref.Endpoint(), test.endpoint)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"UPPER/case",
+		"registry.corp/ns/app:",
+		"registry.corp/ns/app:-tag",
+		"registry.corp/ns/app@sha256:abc",
+		"registry.corp/ns//app",
+		"registry.corp/ns/app/",
+		"-registry.corp/ns/app",
+		"registry.corp:port/ns/app",
+	}
+	for _, input := range tests {
+		if ref, err := Parse(input); err == nil {
+			t.Errorf("%s: expected error, got %+v", input, ref)
+		}
+	}
+}
+
+func TestParseRepository(t *testing.T) {
+	tests := []struct {
+		input string
+		valid bool
+	}{
+		{"docker.io/library/ubuntu", true},
+		{"host:5000/team/sub/app", true},
+		{"host:5000/team/sub/app:1.0", false},
+		{"host:5000/team/sub/app@" + testDigest, false},
+	}
+	for _, test := range tests {
+		_, err := ParseRepository(test.input)
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.input, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: expected error", test.input)
+		}
+	}
+}
+
+func TestParseNamespace(t *testing.T) {
+	tests := []struct {
+		input     string
+		registry  string
+		namespace string
+		valid     bool
+	}{
+		{"host:5000", "host:5000", "", true},
+		{"host:5000/", "host:5000", "", true},
+		{"registry.corp/team/sub", "registry.corp", "team/sub", true},
+		{"localhost/team", "localhost", "team", true},
+		{"index.docker.io/corp", "docker.io", "corp", true},
+		{"team/sub", "", "", false},
+		{"host:5000/Team", "", "", false},
+		{"host:5000/team:1.0", "", "", false},
+	}
+	for _, test := range tests {
+		ref, err := ParseNamespace(test.input)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%s: expected error", test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.input, err)
+			continue
+		}
+		if ref.Registry != test.registry || ref.Repository != test.namespace {
+			t.Errorf("%s: registry %q namespace %q, expected %q %q", test.input, ref.Registry, ref.Repository, test.registry, test.namespace)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := map[string]string{
+		"ubuntu":                               "docker.io/library/ubuntu",
+		"host:5000/ns/app:1.0":                 "host:5000/ns/app:1.0",
+		"host:5000/ns/app@" + testDigest:       "host:5000/ns/app@" + testDigest,
+		"registry-1.docker.io/user/app:stable": "docker.io/user/app:stable",
+	}
+	for input, expected := range tests {
+		ref, err := Parse(input)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", input, err)
+			continue
+		}
+		if ref.String() != expected {
+			t.Errorf("%s: string %q, expected %q", input, ref.String(), expected)
+		}
+	}
+}
+
+func TestLegacyRegistry(t *testing.T) {
+	tests := map[string]string{
+		"myregistry/team/app":                "myregistry",
+		"myregistry/team/app:1.0":            "myregistry",
+		"registry/ns/app@" + testDigest:      "registry",
+		"host/a.b/c__d/e-f:Tag_1":            "host",
+		"myuser/app":                         "",
+		"ubuntu":                             "",
+		"docker.io/library/nginx":            "",
+		"hub.docker.io/library/ubuntu:18.04": "",
+		"registry.corp/team/app":             "",
+		"localhost/team/app":                 "",
+		"Invalid/team/app":                   "",
+	}
+	for input, expected := range tests {
+		if registry := LegacyRegistry(input); registry != expected {
+			t.Errorf("%s: legacy registry %q, expected %q", input, registry, expected)
+		}
+	}
+}
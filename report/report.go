@@ -0,0 +1,59 @@
+package report
+
+import (
+	"errors"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
+)
+
+//ErrIncomplete is returned together with result when some of the tags failed to promote
+var ErrIncomplete = errors.New("promotion completed with errors")
+
+//ErrNoMatchingTags is returned when tag filters rejected all source tags
+var ErrNoMatchingTags = errors.New("image tag filters didn't match any tags")
+
+//Result describes outcome of image or image tags promotion
+type Result struct {
+	//CopiedBlobs lists blobs transferred into destination registry
+	CopiedBlobs []distribution.Descriptor
+	//SkippedBlobs lists blobs which already existed in destination registry
+	SkippedBlobs []distribution.Descriptor
+	//MountedBlobs lists blobs mounted from source repository of the same registry without transferring them
+	MountedBlobs []distribution.Descriptor
+	//Tags lists manifests pushed into destination registry
+	Tags []Tag
+	//FailedTags lists tags which could not be promoted together with the failure reason
+	FailedTags []Tag
+	//UpToDateTags lists tags which were skipped, because destination tag already held the same manifest
+	UpToDateTags []Tag
+	//DeletedTags lists destination tags deleted by pruning
+	DeletedTags []Tag
+}
+
+//Tag describes single promoted (or failed) image tag
+type Tag struct {
+	Image        string
+	Tag          string
+	SourceDigest digest.Digest
+	Digest       digest.Digest
+	Err          error
+}
+
+//CopiedSize returns total size of transferred blobs
+func (r *Result) CopiedSize() int64 {
+	return totalSize(r.CopiedBlobs)
+}
+
+//SkippedSize returns total size of blobs which were not transferred
+func (r *Result) SkippedSize() int64 {
+	return totalSize(r.SkippedBlobs)
+}
+
+func totalSize(blobs []distribution.Descriptor) int64 {
+	var total int64
+	for _, b := range blobs {
+		total = total + b.Size
+	}
+	return total
+}
@@ -0,0 +1,93 @@
+package rewrite
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+//Rule is sed like substitution e.g. s/^rc-(.*)$/\1/ used to rewrite repository paths and tags
+type Rule struct {
+	rule        string
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+//Parse parses s/pattern/replacement/ rule. Any character following s is used as delimiter, e.g. s|^team/|prod/|.
+//Pattern is Go regular expression, replacement refers to groups with \1 (or ${1}) and to whole match with &
+func Parse(s string) (*Rule, error) {
+	if len(s) < 4 || s[0] != 's' {
+		return nil, errors.New("invalid rewrite rule " + s + ". Rule should look like s/pattern/replacement/")
+	}
+	delimiter := s[1]
+	parts := split(s[2:], delimiter)
+	if len(parts) != 3 || parts[2] != "" {
+		return nil, errors.New("invalid rewrite rule " + s + ". Rule should look like s/pattern/replacement/")
+	}
+	pattern, err := regexp.Compile(parts[0])
+	if err != nil {
+		return nil, errors.New("invalid rewrite rule " + s + ". Pattern error: " + err.Error())
+	}
+	return &Rule{rule: s, pattern: pattern, replacement: replacement(parts[1])}, nil
+}
+
+//Apply replaces the first match of the rule pattern. Unmatched value is returned unchanged
+func (r *Rule) Apply(s string) (string, bool) {
+	match := r.pattern.FindStringSubmatchIndex(s)
+	if match == nil {
+		return s, false
+	}
+	expanded := r.pattern.ExpandString(nil, r.replacement, s, match)
+	return s[:match[0]] + string(expanded) + s[match[1]:], true
+}
+
+func (r *Rule) String() string {
+	return r.rule
+}
+
+//split splits rule on unescaped delimiter. Escaped delimiter is unescaped, other escapes are kept
+func split(s string, delimiter byte) []string {
+	parts := make([]string, 0, 3)
+	var part strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == delimiter:
+			part.WriteByte(delimiter)
+			i++
+		case s[i] == '\\' && i+1 < len(s):
+			part.WriteByte(s[i])
+			part.WriteByte(s[i+1])
+			i++
+		case s[i] == delimiter:
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(s[i])
+		}
+	}
+	return append(parts, part.String())
+}
+
+//replacement converts sed replacement into regexp template: \N becomes ${N}, & becomes ${0} and $ is escaped
+func replacement(s string) string {
+	var template strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9':
+			template.WriteString("${" + string(s[i+1]) + "}")
+			i++
+		case s[i] == '\\' && i+1 < len(s):
+			if s[i+1] == '$' {
+				template.WriteString("$$")
+			} else {
+				template.WriteByte(s[i+1])
+			}
+			i++
+		case s[i] == '&':
+			template.WriteString("${0}")
+		default:
+			template.WriteByte(s[i])
+		}
+	}
+	return template.String()
+}
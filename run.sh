@@ -16,25 +16,33 @@ if [ "x${DEST_USERNAME}" == "x" ]
   then
     DEST_USERNAME="builder"
   fi
+TOKEN_FILE=/var/run/secrets/kubernetes.io/serviceaccount/token
+#Secrets are passed through environment variables and files, so they never appear in process listing or logs
 if [ "x${SRC_PASSWORD}" == "x" ]
   then
-     if [ -f /var/run/secrets/kubernetes.io/serviceaccount/token ]; then
+     if [ -f ${TOKEN_FILE} ]; then
 	echo "Using Pod Token authentication"
-        SRC_PASSWORD=$(cat /var/run/secrets/kubernetes.io/serviceaccount/token)
+        SRC_PASSWORD_FLAG="--src-password-file=${TOKEN_FILE}"
         fi
+  else
+    export PROMOTER_SRC_PASSWORD="${SRC_PASSWORD}"
   fi
 if [ "x${DEST_PASSWORD}" == "x" ]
   then
-     if [ -f /var/run/secrets/kubernetes.io/serviceaccount/token ]; then
+     if [ -f ${TOKEN_FILE} ]; then
 	echo "Using Pod Token authentication"
-        DEST_PASSWORD=$(cat /var/run/secrets/kubernetes.io/serviceaccount/token)
+        DEST_PASSWORD_FLAG="--dest-password-file=${TOKEN_FILE}"
         fi
+  else
+    export PROMOTER_DEST_PASSWORD="${DEST_PASSWORD}"
   fi
+unset SRC_PASSWORD DEST_PASSWORD
+export PROMOTER_SRC_USERNAME="${SRC_USERNAME}"
+export PROMOTER_DEST_USERNAME="${DEST_USERNAME}"
 if [ "x${APP_ARGS}" == "x" ]
   then
-    APP_ARGS="${SRC_IMAGE} ${DEST_IMAGE} --src-username=${SRC_USERNAME} --src-password=${SRC_PASSWORD} --dest-username=${DEST_USERNAME} --dest-password=${DEST_PASSWORD} ${ADDITIONAL_FLAGS}"
+    APP_ARGS="${SRC_IMAGE} ${DEST_IMAGE} ${SRC_PASSWORD_FLAG} ${DEST_PASSWORD_FLAG} ${ADDITIONAL_FLAGS}"
 
   fi
 echo "Running ${APP_ARGS}"
 /opt/promoter/promoter push ${APP_ARGS}
-
@@ -0,0 +1,151 @@
+package semver
+
+import (
+	"errors"
+	"strings"
+)
+
+//Constraint is semantic version range e.g. ">=1.4 <2.0", "~1.4", "^2" or "1.x || >=3.1"
+type Constraint struct {
+	//alternatives are joined with ||, comparators of each alternative have to be satisfied all
+	alternatives [][]*comparator
+	original     string
+}
+
+//comparator is range of versions between lower and upper bound. Nil bound is unbounded. Negated comparators match versions outside of the range
+type comparator struct {
+	lower          *Version
+	lowerExclusive bool
+	upper          *Version
+	upperInclusive bool
+	negated        bool
+	//version is the version used in constraint, its prerelease allows matching prereleases of the same release
+	version *Version
+}
+
+//ParseConstraint parses semantic version range. Comparators separated by space or comma have to be satisfied all, alternatives are separated by ||.
+//Supported operators are =, !=, >, >=, <, <=, ~ (patch updates) and ^ (compatible updates). Missing components and x or * wildcards match any value,
+//so =1.4 and 1.4.x match 1.4.7 and <=1.4 matches versions below 1.5.0
+func ParseConstraint(s string) (*Constraint, error) {
+	c := &Constraint{original: s}
+	for _, alternative := range strings.Split(s, "||") {
+		fields := strings.Fields(strings.Replace(alternative, ",", " ", -1))
+		comparators := make([]*comparator, 0, len(fields))
+		for i := 0; i < len(fields); i++ {
+			field := fields[i]
+			//operator separated from version by space e.g. ">= 1.4"
+			if strings.Trim(field, "=!<>~^") == "" && i+1 < len(fields) {
+				field = field + fields[i+1]
+				i++
+			}
+			comp, err := parseComparator(field)
+			if err != nil {
+				return nil, errors.New("invalid semantic version constraint " + s + ": " + err.Error())
+			}
+			comparators = append(comparators, comp)
+		}
+		if len(comparators) == 0 {
+			return nil, errors.New("invalid semantic version constraint " + s + ": empty range")
+		}
+		c.alternatives = append(c.alternatives, comparators)
+	}
+	return c, nil
+}
+
+func (c *Constraint) String() string {
+	return c.original
+}
+
+//Check reports whether version satisfies the constraint. Prereleases match only when constraint mentions prerelease of the same release
+func (c *Constraint) Check(v *Version) bool {
+	for _, comparators := range c.alternatives {
+		matched := true
+		prereleaseAllowed := v.Prerelease == ""
+		for _, comp := range comparators {
+			if !comp.check(v) {
+				matched = false
+				break
+			}
+			if comp.version != nil && comp.version.Prerelease != "" && comp.version.sameRelease(v) {
+				prereleaseAllowed = true
+			}
+		}
+		if matched && prereleaseAllowed {
+			return true
+		}
+	}
+	return false
+}
+
+func (comp *comparator) check(v *Version) bool {
+	in := true
+	if comp.lower != nil {
+		c := v.Compare(comp.lower)
+		in = c > 0 || (c == 0 && !comp.lowerExclusive)
+	}
+	if in && comp.upper != nil {
+		c := v.Compare(comp.upper)
+		in = c < 0 || (c == 0 && comp.upperInclusive)
+	}
+	return in != comp.negated
+}
+
+func parseComparator(s string) (*comparator, error) {
+	version := strings.TrimLeft(s, "=!<>~^")
+	operator := s[:len(s)-len(version)]
+	if version == "*" || version == "x" || version == "X" {
+		return &comparator{}, nil
+	}
+	//wildcards are the same as missing components
+	for _, wildcard := range []string{".x", ".X", ".*"} {
+		if i := strings.Index(version, wildcard); i >= 0 {
+			version = version[:i]
+		}
+	}
+	v, err := Parse(version)
+	if err != nil {
+		return nil, err
+	}
+	//exact is used when all components are specified, otherwise operators apply to the whole partial range e.g. 1.4.x
+	exact := v.parts == 3
+	switch operator {
+	case "", "=", "==", "!=":
+		comp := &comparator{lower: v, upper: v.next(v.parts), negated: operator == "!=", version: v}
+		if exact {
+			comp.upper = v
+			comp.upperInclusive = true
+		}
+		return comp, nil
+	case ">":
+		if exact {
+			return &comparator{lower: v, lowerExclusive: true, version: v}, nil
+		}
+		return &comparator{lower: v.next(v.parts), version: v}, nil
+	case ">=":
+		return &comparator{lower: v, version: v}, nil
+	case "<":
+		return &comparator{upper: v, version: v}, nil
+	case "<=":
+		if exact {
+			return &comparator{upper: v, upperInclusive: true, version: v}, nil
+		}
+		return &comparator{upper: v.next(v.parts), version: v}, nil
+	case "~":
+		parts := 2
+		if v.parts == 1 {
+			parts = 1
+		}
+		return &comparator{lower: v, upper: v.next(parts), version: v}, nil
+	case "^":
+		//changes left of the first non-zero component are incompatible e.g. ^0.4 allows 0.4.x only
+		parts := 1
+		if v.Major == 0 && v.parts >= 2 {
+			parts = 2
+			if v.Minor == 0 && exact {
+				parts = 3
+			}
+		}
+		return &comparator{lower: v, upper: v.next(parts), version: v}, nil
+	}
+	return nil, errors.New("unknown operator " + operator)
+}
@@ -0,0 +1,124 @@
+package semver
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var versionRegexp = regexp.MustCompile(`^v?(0|[1-9][0-9]*)(?:\.(0|[1-9][0-9]*))?(?:\.(0|[1-9][0-9]*))?(?:-([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?(?:\+([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?$`)
+
+//Version is semantic version parsed from image tag. Tags such as 1.4 or v2 are accepted with missing components set to zero
+type Version struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	Prerelease string
+	Build      string
+	//parts is number of numeric components specified e.g. 2 for 1.4
+	parts    int
+	original string
+}
+
+//Parse parses semantic version with optional v prefix e.g. 1.4.2, v1.4 or 2.0.0-rc.1
+func Parse(s string) (*Version, error) {
+	m := versionRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return nil, errors.New("invalid semantic version " + s)
+	}
+	v := &Version{Prerelease: m[4], Build: m[5], original: s}
+	for i, component := range []*uint64{&v.Major, &v.Minor, &v.Patch} {
+		if m[i+1] == "" {
+			break
+		}
+		n, err := strconv.ParseUint(m[i+1], 10, 64)
+		if err != nil {
+			return nil, errors.New("invalid semantic version " + s + ": " + err.Error())
+		}
+		*component = n
+		v.parts = i + 1
+	}
+	return v, nil
+}
+
+func (v *Version) String() string {
+	return v.original
+}
+
+//Compare returns -1, 0 or 1 when version is lower, equal or greater than other version. Build metadata is ignored
+func (v *Version) Compare(other *Version) int {
+	for _, c := range [][2]uint64{{v.Major, other.Major}, {v.Minor, other.Minor}, {v.Patch, other.Patch}} {
+		if c[0] < c[1] {
+			return -1
+		}
+		if c[0] > c[1] {
+			return 1
+		}
+	}
+	return comparePrerelease(v.Prerelease, other.Prerelease)
+}
+
+//comparePrerelease compares dot separated prerelease identifiers. Release is greater than any of its prereleases
+func comparePrerelease(a string, b string) int {
+	if a == b {
+		return 0
+	}
+	if a == "" {
+		return 1
+	}
+	if b == "" {
+		return -1
+	}
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.ParseUint(as[i], 10, 64)
+		bn, bErr := strconv.ParseUint(bs[i], 10, 64)
+		switch {
+		case aErr == nil && bErr == nil:
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+		case aErr == nil:
+			//numeric identifiers have lower precedence than alphanumeric ones
+			return -1
+		case bErr == nil:
+			return 1
+		case as[i] != bs[i]:
+			if as[i] < bs[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+//sameRelease reports whether versions share major, minor and patch numbers
+func (v *Version) sameRelease(other *Version) bool {
+	return v.Major == other.Major && v.Minor == other.Minor && v.Patch == other.Patch
+}
+
+//next returns lowest prerelease of the version following partial version e.g. 1.5.0-0 for 1.4
+func (v *Version) next(parts int) *Version {
+	next := &Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch, Prerelease: "0", parts: 3}
+	switch parts {
+	case 1:
+		next.Major, next.Minor, next.Patch = v.Major+1, 0, 0
+	case 2:
+		next.Minor, next.Patch = v.Minor+1, 0
+	default:
+		next.Patch = v.Patch + 1
+	}
+	return next
+}
@@ -0,0 +1,120 @@
+package semver
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		valid bool
+	}{
+		{"1.4.2", true},
+		{"v1.4", true},
+		{"2", true},
+		{"2.0.0-rc.1+build.5", true},
+		{"1.4.2-alpine", true},
+		{"latest", false},
+		{"1.4.2.1", false},
+		{"01.4", false},
+		{"1.4-", false},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.input)
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.input, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: expected error", test.input)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a        string
+		b        string
+		expected int
+	}{
+		{"1.4.2", "1.4.2", 0},
+		{"1.4", "1.4.0", 0},
+		{"v1.4.2", "1.4.10", -1},
+		{"2.0.0", "1.99.99", 1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-rc.1", "1.0.0-beta.11", 1},
+		{"1.0.0+build.1", "1.0.0+build.2", 0},
+	}
+	for _, test := range tests {
+		a, _ := Parse(test.a)
+		b, _ := Parse(test.b)
+		if c := a.Compare(b); c != test.expected {
+			t.Errorf("%s compared to %s: %d, expected %d", test.a, test.b, c, test.expected)
+		}
+	}
+}
+
+func TestConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		expected   bool
+	}{
+		{">=1.4 <2.0", "1.4.0", true},
+		{">=1.4 <2.0", "1.9.9", true},
+		{">=1.4 <2.0", "2.0.0", false},
+		{">=1.4 <2.0", "1.3.9", false},
+		{">=1.4 <2.0", "1.5.0-rc.1", false},
+		{">=1.4, <2.0", "1.5", true},
+		{">= 1.4", "1.4.1", true},
+		{"1.4", "1.4.7", true},
+		{"1.4.x", "1.5.0", false},
+		{"=1.4.2", "1.4.2", true},
+		{"=1.4.2", "1.4.3", false},
+		{"!=1.4", "1.4.3", false},
+		{"!=1.4", "1.5.0", true},
+		{">1.4", "1.4.9", false},
+		{">1.4", "1.5.0", true},
+		{">1.4.2", "1.4.3", true},
+		{"<=1.4", "1.4.9", true},
+		{"<=1.4.2", "1.4.3", false},
+		{"~1.4.2", "1.4.9", true},
+		{"~1.4.2", "1.5.0", false},
+		{"~1", "1.9.0", true},
+		{"^1.4", "1.9.0", true},
+		{"^1.4", "2.0.0", false},
+		{"^0.4", "0.4.5", true},
+		{"^0.4", "0.5.0", false},
+		{"^0.0.3", "0.0.4", false},
+		{">=2.0.0-rc.1", "2.0.0-rc.2", true},
+		{">=2.0.0-rc.1", "2.1.0-rc.1", false},
+		{"1.x || >=3.1", "3.2.0", true},
+		{"1.x || >=3.1", "2.0.0", false},
+		{"*", "0.0.1", true},
+	}
+	for _, test := range tests {
+		c, err := ParseConstraint(test.constraint)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.constraint, err)
+			continue
+		}
+		v, err := Parse(test.version)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.version, err)
+			continue
+		}
+		if c.Check(v) != test.expected {
+			t.Errorf("%s check %s: %t, expected %t", test.constraint, test.version, !test.expected, test.expected)
+		}
+	}
+}
+
+func TestParseConstraintInvalid(t *testing.T) {
+	for _, input := range []string{"", ">=", "=>1.4", ">=1.4 ||", "1.4 <latest"} {
+		if _, err := ParseConstraint(input); err == nil {
+			t.Errorf("%s: expected error", input)
+		}
+	}
+}
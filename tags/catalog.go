@@ -0,0 +1,74 @@
+package tags
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/heroku/docker-registry-client/registry"
+)
+
+//catalogPageSize is number of repositories requested per catalog page
+const catalogPageSize = 100
+
+//nextLinkRegexp matches RFC 5988 Link header pointing to the next page e.g. </v2/_catalog?last=app&n=100>; rel="next"
+var nextLinkRegexp = regexp.MustCompile(`^ *<?([^;>]+)>? *(?:;[^;]*)*; *rel="?next"?(?:;.*)?`)
+
+type catalogResponse struct {
+	Repositories []string `json:"repositories"`
+}
+
+//Repositories walks registry catalog page by page and returns repositories of the namespace (all repositories when namespace is empty).
+//Registries return relative next page links, which vendored registry client can not follow
+func Repositories(ctx context.Context, hub *registry.Registry, namespace string) ([]string, error) {
+	repositories := make([]string, 0)
+	pageURL := fmt.Sprintf("%s/v2/_catalog?n=%d", hub.URL, catalogPageSize)
+	for pageURL != "" {
+		hub.Logf("registry.repositories url=%s", pageURL)
+		req, err := http.NewRequest("GET", pageURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := hub.Client.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list repositories of registry %s: %w", hub.URL, err)
+		}
+		page := &catalogResponse{}
+		err = json.NewDecoder(resp.Body).Decode(page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse repositories of registry %s: %w", hub.URL, err)
+		}
+		for _, repository := range page.Repositories {
+			if namespace == "" || strings.HasPrefix(repository, namespace+"/") {
+				repositories = append(repositories, repository)
+			}
+		}
+		if pageURL, err = nextPage(pageURL, resp); err != nil {
+			return nil, err
+		}
+	}
+	return repositories, nil
+}
+
+//nextPage returns absolute URL of the next catalog page or empty string for the last page
+func nextPage(pageURL string, resp *http.Response) (string, error) {
+	for _, link := range resp.Header[http.CanonicalHeaderKey("Link")] {
+		if parts := nextLinkRegexp.FindStringSubmatch(link); parts != nil {
+			base, err := url.Parse(pageURL)
+			if err != nil {
+				return "", err
+			}
+			next, err := base.Parse(parts[1])
+			if err != nil {
+				return "", fmt.Errorf("invalid catalog page link %s: %w", link, err)
+			}
+			return next.String(), nil
+		}
+	}
+	return "", nil
+}
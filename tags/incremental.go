@@ -0,0 +1,81 @@
+package tags
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/Jeffail/tunny"
+	"github.com/docker/distribution/digest"
+	"github.com/heroku/docker-registry-client/registry"
+	"github.com/vbaksa/promoter/manifest"
+)
+
+//tagStatus compares source tag with destination tag before promotion
+type tagStatus struct {
+	tag string
+	//srcDigest is digest of source manifest, empty when registry did not report it or image index is filtered by platforms
+	srcDigest digest.Digest
+	//destDigest is digest of destination manifest, empty when destination tag does not exist
+	destDigest digest.Digest
+}
+
+//upToDate reports whether destination tag already holds manifest with the digest
+func (s *tagStatus) upToDate(srcDigest digest.Digest) bool {
+	return !s.isNew() && s.destDigest == srcDigest
+}
+
+//isNew reports whether destination tag does not exist. Tags whose digest could not be read are treated as new
+func (s *tagStatus) isNew() bool {
+	return s.destDigest == ""
+}
+
+//compareTags reads digests of source and destination manifests using HEAD requests. Source digest is not read when srcHub is nil
+//or image indexes are filtered by platforms, because filtered index differs from the source one
+func (th *TagPush) compareTags(ctx context.Context, srcHub *registry.Registry, destHub *registry.Registry, tags []string, destTags map[string]string) map[string]*tagStatus {
+	limiter := th.Connections.Limiter(th.Concurrency)
+	queue := tunny.NewFunc(th.Concurrency.WithDefaults().Manifests, func(payload interface{}) interface{} {
+		s := payload.(*tagStatus)
+		release, err := limiter.Acquire(ctx, srcHub, destHub)
+		if err != nil {
+			return s
+		}
+		defer release()
+		if s.destDigest, err = manifest.Head(ctx, destHub, th.DestImage, destTags[s.tag]); err != nil {
+			destHub.Logf("tags.compare tag=%s destination error=%s", destTags[s.tag], err.Error())
+			return s
+		}
+		if s.destDigest != "" && srcHub != nil && len(th.Platforms) == 0 {
+			if s.srcDigest, err = manifest.Head(ctx, srcHub, th.SrcImage, s.tag); err != nil {
+				srcHub.Logf("tags.compare tag=%s source error=%s", s.tag, err.Error())
+			}
+		}
+		return s
+	})
+	defer queue.Close()
+	result := make(chan *tagStatus)
+	for _, tag := range tags {
+		go func(tag string) {
+			result <- queue.Process(&tagStatus{tag: tag}).(*tagStatus)
+		}(tag)
+	}
+	statuses := make(map[string]*tagStatus, len(tags))
+	for range tags {
+		s := <-result
+		statuses[s.tag] = s
+	}
+	return statuses
+}
+
+//printTagCounts prints number of up-to-date, updated and new tags
+func printTagCounts(out io.Writer, upToDate int, statuses map[string]*tagStatus, tags []string) {
+	updated, created := 0, 0
+	for _, tag := range tags {
+		if statuses[tag].isNew() {
+			created++
+		} else {
+			updated++
+		}
+	}
+	fmt.Fprintf(out, "Tags up-to-date: %d, updated: %d, new: %d \n", upToDate, updated, created)
+}
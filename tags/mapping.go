@@ -0,0 +1,76 @@
+package tags
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/vbaksa/promoter/reference"
+	"github.com/vbaksa/promoter/rewrite"
+)
+
+//tagTemplateData is data available to TagTemplate
+type tagTemplateData struct {
+	//Tag is source tag after TagRewrite
+	Tag string
+	//SourceTag is tag in source repository
+	SourceTag string
+	//Repository is source repository
+	Repository string
+}
+
+//mapTags returns destination tag of every source tag. Tags listed in TagMapping are mapped explicitly, other tags are
+//rewritten by TagRewrite and then by TagTemplate. Mapping fails when two source tags map to the same destination tag
+func (th *TagPush) mapTags(tags []string) (map[string]string, error) {
+	var rule *rewrite.Rule
+	var tmpl *template.Template
+	var err error
+	if th.TagRewrite != "" {
+		if rule, err = rewrite.Parse(th.TagRewrite); err != nil {
+			return nil, err
+		}
+	}
+	if th.TagTemplate != "" {
+		if tmpl, err = template.New("tag").Option("missingkey=error").Parse(th.TagTemplate); err != nil {
+			return nil, fmt.Errorf("invalid tag template: %w", err)
+		}
+	}
+	destTags := make(map[string]string, len(tags))
+	sources := make(map[string][]string)
+	for _, tag := range tags {
+		destTag := tag
+		if mapped, ok := th.TagMapping[tag]; ok && mapped != "" {
+			destTag = mapped
+		} else {
+			if rule != nil {
+				destTag, _ = rule.Apply(destTag)
+			}
+			if tmpl != nil {
+				var b bytes.Buffer
+				if err := tmpl.Execute(&b, tagTemplateData{Tag: destTag, SourceTag: tag, Repository: th.SrcImage}); err != nil {
+					return nil, fmt.Errorf("failed to map tag %s: %w", tag, err)
+				}
+				destTag = b.String()
+			}
+		}
+		if !reference.IsValidTag(destTag) {
+			return nil, errors.New("tag " + tag + " is mapped to invalid tag " + destTag)
+		}
+		destTags[tag] = destTag
+		sources[destTag] = append(sources[destTag], tag)
+	}
+	collisions := make([]string, 0)
+	for destTag, tags := range sources {
+		if len(tags) > 1 {
+			collisions = append(collisions, "tags "+strings.Join(tags, ", ")+" map to the same tag "+destTag)
+		}
+	}
+	if len(collisions) > 0 {
+		sort.Strings(collisions)
+		return nil, errors.New("tag mapping collision: " + strings.Join(collisions, "; "))
+	}
+	return destTags, nil
+}
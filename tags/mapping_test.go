@@ -0,0 +1,68 @@
+package tags
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMapTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		th       TagPush
+		tags     []string
+		expected map[string]string
+	}{
+		{"identity", TagPush{}, []string{"1.0", "latest"}, map[string]string{"1.0": "1.0", "latest": "latest"}},
+		{"explicit mapping", TagPush{TagMapping: map[string]string{"1.0": "stable"}}, []string{"1.0", "2.0"}, map[string]string{"1.0": "stable", "2.0": "2.0"}},
+		{"rewrite", TagPush{TagRewrite: `s/^rc-(.*)$/\1/`}, []string{"rc-1.0", "dev"}, map[string]string{"rc-1.0": "1.0", "dev": "dev"}},
+		{"template", TagPush{TagTemplate: "{{.Tag}}-prod"}, []string{"1.0"}, map[string]string{"1.0": "1.0-prod"}},
+		{"rewrite and template", TagPush{TagRewrite: `s/^v//`, TagTemplate: "{{.Tag}}-{{.SourceTag}}"}, []string{"v1.0"}, map[string]string{"v1.0": "1.0-v1.0"}},
+		{"mapping skips template", TagPush{TagMapping: map[string]string{"1.0": "stable"}, TagTemplate: "{{.Tag}}-prod"}, []string{"1.0", "2.0"}, map[string]string{"1.0": "stable", "2.0": "2.0-prod"}},
+		{"mapping swaps tags", TagPush{TagMapping: map[string]string{"a": "b", "b": "a"}}, []string{"a", "b"}, map[string]string{"a": "b", "b": "a"}},
+	}
+	for _, test := range tests {
+		destTags, err := test.th.mapTags(test.tags)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if len(destTags) != len(test.expected) {
+			t.Errorf("%s: mapped %v, expected %v", test.name, destTags, test.expected)
+			continue
+		}
+		for tag, expected := range test.expected {
+			if destTags[tag] != expected {
+				t.Errorf("%s: tag %s mapped to %q, expected %q", test.name, tag, destTags[tag], expected)
+			}
+		}
+	}
+}
+
+func TestMapTagsInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		th    TagPush
+		tags  []string
+		error string
+	}{
+		{"mapping collision", TagPush{TagMapping: map[string]string{"1.0": "stable", "1.1": "stable"}}, []string{"1.0", "1.1"}, "tags 1.0, 1.1 map to the same tag stable"},
+		{"mapping onto unmapped tag", TagPush{TagMapping: map[string]string{"1.0": "2.0"}}, []string{"1.0", "2.0"}, "map to the same tag 2.0"},
+		{"rewrite collision", TagPush{TagRewrite: `s/^rc-//`}, []string{"1.0", "rc-1.0"}, "map to the same tag 1.0"},
+		{"template collision", TagPush{TagTemplate: "prod"}, []string{"1.0", "2.0"}, "map to the same tag prod"},
+		{"invalid rewrite", TagPush{TagRewrite: "s/(/x/"}, []string{"1.0"}, ""},
+		{"template syntax", TagPush{TagTemplate: "{{.Tag"}, []string{"1.0"}, "invalid tag template"},
+		{"template missing key", TagPush{TagTemplate: "{{.Unknown}}"}, []string{"1.0"}, "failed to map tag 1.0"},
+		{"invalid destination tag", TagPush{TagTemplate: "{{.Tag}}/prod"}, []string{"1.0"}, "mapped to invalid tag 1.0/prod"},
+		{"empty destination tag", TagPush{TagRewrite: `s/.*//`}, []string{"1.0"}, "mapped to invalid tag"},
+	}
+	for _, test := range tests {
+		destTags, err := test.th.mapTags(test.tags)
+		if err == nil {
+			t.Errorf("%s: expected error, got %v", test.name, destTags)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.error) {
+			t.Errorf("%s: error %q, expected it to contain %q", test.name, err.Error(), test.error)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package tags
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/vbaksa/promoter/connection"
+	"github.com/vbaksa/promoter/reference"
+	"github.com/vbaksa/promoter/rewrite"
+)
+
+//Mirror holds registry or namespace mirroring structure
+type Mirror struct {
+	//Template configures registries, credentials, tag filter and transfer of every repository promotion. SrcImage and DestImage are set per repository
+	Template TagPush
+	//SrcNamespace and DestNamespace are repository path prefixes e.g. team/sub. Whole registry is mirrored when SrcNamespace is empty
+	SrcNamespace  string
+	DestNamespace string
+	//Include and Exclude filter source repositories by full repository path. All repositories are included when Include is empty
+	Include []*regexp.Regexp
+	Exclude []*regexp.Regexp
+	//Rewrite maps repository path relative to SrcNamespace before it is placed into DestNamespace
+	Rewrite *rewrite.Rule
+}
+
+//MirrorRepositories lists source repositories using catalog API and promotes tags of every selected repository, see Sync
+func (m *Mirror) MirrorRepositories(ctx context.Context) (*SyncResult, error) {
+	fmt.Fprintln(m.Template.out(), "Preparing mirror")
+	if m.Template.Connections == nil {
+		m.Template.Connections = connection.NewPool()
+	}
+	srcHub, _, err := m.Template.Connections.InitConnection(m.Template.srcConfig(), m.Template.destConfig())
+	if err != nil {
+		return nil, err
+	}
+	repositories, err := Repositories(ctx, srcHub, m.SrcNamespace)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(m.Template.out(), "Source registry contains %d repositories \n", len(repositories))
+	pushes, err := m.pushes(repositories)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(m.Template.out(), "Mirroring %d repositories \n", len(pushes))
+	//Repositories without tags matching tag filters are expected when mirroring, so they are skipped rather than failed
+	return syncPushes(ctx, pushes, m.Template.Output, true)
+}
+
+//pushes builds promotions of selected repositories. Destination repositories are validated before anything is pushed
+func (m *Mirror) pushes(repositories []string) ([]*TagPush, error) {
+	pushes := make([]*TagPush, 0, len(repositories))
+	sources := make(map[string]string)
+	for _, repository := range repositories {
+		if !m.selected(repository) {
+			continue
+		}
+		destImage := m.destRepository(repository)
+		if !reference.IsValidRepository(destImage) {
+			return nil, errors.New("repository " + repository + " would be mirrored into invalid repository " + destImage)
+		}
+		if other, ok := sources[destImage]; ok {
+			return nil, errors.New("repositories " + other + " and " + repository + " would be both mirrored into " + destImage)
+		}
+		sources[destImage] = repository
+		th := m.Template
+		th.SrcImage = repository
+		th.DestImage = destImage
+		pushes = append(pushes, &th)
+		fmt.Fprintf(m.Template.out(), "  %s -> %s\n", repository, destImage)
+	}
+	if len(pushes) == 0 {
+		return nil, errors.New("no repositories selected for mirroring")
+	}
+	return pushes, nil
+}
+
+//selected reports whether repository passes include and exclude filters
+func (m *Mirror) selected(repository string) bool {
+	included := len(m.Include) == 0
+	for _, r := range m.Include {
+		if r.MatchString(repository) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, r := range m.Exclude {
+		if r.MatchString(repository) {
+			return false
+		}
+	}
+	return true
+}
+
+//destRepository maps source repository path into destination namespace
+func (m *Mirror) destRepository(repository string) string {
+	path := repository
+	if m.SrcNamespace != "" {
+		path = strings.TrimPrefix(repository, m.SrcNamespace+"/")
+	}
+	if m.Rewrite != nil {
+		path, _ = m.Rewrite.Apply(path)
+	}
+	if m.DestNamespace == "" {
+		return path
+	}
+	return m.DestNamespace + "/" + path
+}
@@ -0,0 +1,104 @@
+package tags
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/vbaksa/promoter/report"
+	"github.com/vbaksa/promoter/rewrite"
+)
+
+func TestMirrorPushes(t *testing.T) {
+	repositories := []string{"team/app", "team/legacy-db", "team/tmp-build", "other/app"}
+	tests := []struct {
+		name    string
+		mirror  Mirror
+		rewrite string
+		//expected maps source repositories to destination repositories
+		expected map[string]string
+		error    string
+	}{
+		{"namespace", Mirror{SrcNamespace: "team", DestNamespace: "backup/team"}, "", map[string]string{"team/app": "backup/team/app", "team/legacy-db": "backup/team/legacy-db", "team/tmp-build": "backup/team/tmp-build"}, ""},
+		{"whole registry", Mirror{Exclude: []*regexp.Regexp{regexp.MustCompile("/tmp-")}}, "", map[string]string{"team/app": "team/app", "team/legacy-db": "team/legacy-db", "other/app": "other/app"}, ""},
+		{"rewrite", Mirror{SrcNamespace: "team", DestNamespace: "backup", Include: []*regexp.Regexp{regexp.MustCompile("^team/legacy-")}}, `s/^legacy-(.*)$/\1/`, map[string]string{"team/legacy-db": "backup/db"}, ""},
+		{"rewrite collision", Mirror{SrcNamespace: "team"}, `s/^legacy-db$/app/`, nil, "would be both mirrored into app"},
+		{"rewrite to uppercase", Mirror{SrcNamespace: "team", DestNamespace: "backup"}, `s/^app$/App/`, nil, "team/app would be mirrored into invalid repository backup/App"},
+		{"rewrite to empty path", Mirror{SrcNamespace: "team", DestNamespace: "backup"}, `s/^app$//`, nil, "team/app would be mirrored into invalid repository backup/"},
+		{"rewrite to empty component", Mirror{SrcNamespace: "team"}, `s/^app$/a\/\/b/`, nil, "invalid repository a//b"},
+		{"nothing selected", Mirror{SrcNamespace: "none"}, "", nil, "no repositories selected"},
+	}
+	for _, test := range tests {
+		m := test.mirror
+		if test.rewrite != "" {
+			rule, err := rewrite.Parse(test.rewrite)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.Rewrite = rule
+		}
+		selected := repositories
+		if m.SrcNamespace != "" {
+			selected = nil
+			for _, repository := range repositories {
+				if strings.HasPrefix(repository, m.SrcNamespace+"/") {
+					selected = append(selected, repository)
+				}
+			}
+		}
+		pushes, err := m.pushes(selected)
+		if test.error != "" {
+			if err == nil || !strings.Contains(err.Error(), test.error) {
+				t.Errorf("%s: error %v, expected it to contain %q", test.name, err, test.error)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if len(pushes) != len(test.expected) {
+			t.Errorf("%s: %d repositories mirrored, expected %d", test.name, len(pushes), len(test.expected))
+		}
+		for _, th := range pushes {
+			if test.expected[th.SrcImage] != th.DestImage {
+				t.Errorf("%s: %s mirrored into %s, expected %s", test.name, th.SrcImage, th.DestImage, test.expected[th.SrcImage])
+			}
+		}
+	}
+}
+
+func TestSyncSkipUnmatched(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case "/v2/team/app/tags/list":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"name": "team/app", "tags": ["1.0", "1.1"]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	for _, skipUnmatched := range []bool{true, false} {
+		th := &TagPush{SrcRegistry: server.URL, SrcImage: "team/app", DestRegistry: server.URL, DestImage: "backup/app", TagRegexp: `^2\.`}
+		var out bytes.Buffer
+		result, err := syncPushes(context.Background(), []*TagPush{th}, &out, skipUnmatched)
+		entry := result.Entries[0]
+		if skipUnmatched {
+			if err != nil || !entry.Skipped || entry.Err != nil || !strings.Contains(out.String(), "Promotions: 1, skipped: 1, failed: 0") {
+				t.Errorf("skip unmatched: error %v, entry %+v, expected skipped promotion. Output:\n%s", err, entry, out.String())
+			}
+			continue
+		}
+		if !errors.Is(err, report.ErrIncomplete) || entry.Skipped || !errors.Is(entry.Err, report.ErrNoMatchingTags) {
+			t.Errorf("error %v, entry %+v, expected failed promotion", err, entry)
+		}
+	}
+}
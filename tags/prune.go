@@ -0,0 +1,215 @@
+package tags
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/Jeffail/tunny"
+	"github.com/docker/distribution/digest"
+	"github.com/heroku/docker-registry-client/registry"
+	"github.com/vbaksa/promoter/manifest"
+	"github.com/vbaksa/promoter/report"
+)
+
+//pruneTags deletes destination tags which are not among promoted tags. Deleting manifest removes all tags referencing it,
+//so tags sharing manifest with a promoted tag, or referencing child manifest of promoted image index, are kept. Children
+//holds child manifest digests of already known image indexes. In dry run mode tags are only listed
+func (th *TagPush) pruneTags(ctx context.Context, destHub *registry.Registry, destTags map[string]string, statuses map[string]*tagStatus, children map[digest.Digest][]digest.Digest, result *report.Result) error {
+	fmt.Fprintln(th.out(), "Pruning destination tags...")
+	existing, err := destHub.Tags(th.DestImage)
+	var statusErr *registry.HttpStatusError
+	if errors.As(err, &statusErr) && statusErr.Response.StatusCode == http.StatusNotFound {
+		existing = nil
+	} else if err != nil {
+		return fmt.Errorf("failed to get destination image %s tags: %w", th.DestImage, err)
+	}
+	keep := make(map[string]bool)
+	for _, destTag := range destTags {
+		keep[destTag] = true
+	}
+	keepDigests := keptDigests(destTags, statuses, result)
+	candidates := make([]string, 0)
+	for _, tag := range existing {
+		if !keep[tag] {
+			candidates = append(candidates, tag)
+		}
+	}
+	sort.Strings(candidates)
+	statuses = th.destinationDigests(ctx, destHub, candidates)
+	//Child manifests of kept image indexes are kept too. Destination indexes are read only when some tag could be deleted
+	if children == nil {
+		children = make(map[digest.Digest][]digest.Digest)
+	}
+	if pruneCandidates(candidates, statuses, keepDigests) {
+		unknown := make([]digest.Digest, 0, len(keepDigests))
+		for dgst := range keepDigests {
+			if _, ok := children[dgst]; !ok {
+				unknown = append(unknown, dgst)
+			}
+		}
+		read, err := th.indexChildren(ctx, destHub, unknown)
+		if err != nil {
+			return err
+		}
+		for dgst, digests := range read {
+			children[dgst] = digests
+		}
+	}
+	deletions := th.pruneDeletions(candidates, statuses, keepDigests, children)
+	if len(deletions) == 0 {
+		fmt.Fprintln(th.out(), "No destination tags to prune")
+		return nil
+	}
+	if th.PruneMax > 0 && len(deletions) > th.PruneMax {
+		for _, tag := range deletions {
+			fmt.Fprintf(th.out(), "  would delete  %s (%s)\n", tag.Tag, tag.Digest)
+		}
+		return fmt.Errorf("pruning refused: %d destination tags would be deleted, which is more than allowed maximum %d", len(deletions), th.PruneMax)
+	}
+	if th.DryRun {
+		for _, tag := range deletions {
+			fmt.Fprintf(th.out(), "  would delete  %s (%s)\n", tag.Tag, tag.Digest)
+		}
+		fmt.Fprintf(th.out(), "%d destination tags would be deleted \n", len(deletions))
+		return nil
+	}
+	//Tags sharing manifest are deleted together, so every manifest is deleted once
+	deleted := make(map[digest.Digest]error)
+	failed := 0
+	for _, tag := range deletions {
+		err, done := deleted[tag.Digest]
+		if !done {
+			if err = ctx.Err(); err == nil {
+				err = destHub.DeleteManifest(th.DestImage, tag.Digest)
+			}
+			deleted[tag.Digest] = err
+		}
+		if err != nil {
+			fmt.Fprintf(th.out(), "Failed to delete tag %s. Error: %s \n", th.DestImage+":"+tag.Tag, err.Error())
+			tag.Err = fmt.Errorf("failed to delete image %s:%s: %w", th.DestImage, tag.Tag, err)
+			result.FailedTags = append(result.FailedTags, tag)
+			failed++
+			continue
+		}
+		fmt.Fprintf(th.out(), "  deleted  %s (%s)\n", tag.Tag, tag.Digest)
+		result.DeletedTags = append(result.DeletedTags, tag)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d destination tags failed to prune: %w", failed, len(deletions), report.ErrIncomplete)
+	}
+	return nil
+}
+
+//destinationDigests reads digests of destination tags
+func (th *TagPush) destinationDigests(ctx context.Context, destHub *registry.Registry, tags []string) map[string]*tagStatus {
+	identity := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		identity[tag] = tag
+	}
+	return th.compareTags(ctx, nil, destHub, tags, identity)
+}
+
+//keptDigests returns manifest digests of kept destination tags mapped to the tag keeping them
+func keptDigests(destTags map[string]string, statuses map[string]*tagStatus, result *report.Result) map[digest.Digest]string {
+	keepDigests := make(map[digest.Digest]string)
+	for tag, destTag := range destTags {
+		if status, ok := statuses[tag]; ok && status.destDigest != "" {
+			keepDigests[status.destDigest] = destTag
+		}
+	}
+	for _, tag := range append(result.Tags, result.UpToDateTags...) {
+		keepDigests[tag.Digest] = tag.Tag
+	}
+	return keepDigests
+}
+
+//pruneCandidates reports whether any candidate tag has known manifest which is not kept directly
+func pruneCandidates(candidates []string, statuses map[string]*tagStatus, keepDigests map[digest.Digest]string) bool {
+	for _, tag := range candidates {
+		if dgst := statuses[tag].destDigest; dgst != "" {
+			if _, ok := keepDigests[dgst]; !ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//pruneDeletions selects candidate tags to delete. Tags with unknown manifest digest and tags referencing manifest of kept
+//tag, or child manifest of kept image index, are skipped
+func (th *TagPush) pruneDeletions(candidates []string, statuses map[string]*tagStatus, keepDigests map[digest.Digest]string, children map[digest.Digest][]digest.Digest) []report.Tag {
+	kept := make(map[digest.Digest]string, len(keepDigests))
+	for dgst, tag := range keepDigests {
+		kept[dgst] = tag
+		for _, child := range children[dgst] {
+			if _, ok := kept[child]; !ok {
+				kept[child] = tag
+			}
+		}
+	}
+	deletions := make([]report.Tag, 0, len(candidates))
+	for _, tag := range candidates {
+		dgst := statuses[tag].destDigest
+		if dgst == "" {
+			fmt.Fprintf(th.out(), "Skipping tag %s, its manifest digest is unknown \n", th.DestImage+":"+tag)
+			continue
+		}
+		if keptBy, ok := kept[dgst]; ok {
+			fmt.Fprintf(th.out(), "Skipping tag %s, its manifest is referenced by promoted tag %s \n", th.DestImage+":"+tag, keptBy)
+			continue
+		}
+		deletions = append(deletions, report.Tag{Image: th.DestImage, Tag: tag, Digest: dgst})
+	}
+	return deletions
+}
+
+//indexChildren reads destination manifests and returns child manifest digests of image indexes among them
+func (th *TagPush) indexChildren(ctx context.Context, destHub *registry.Registry, digests []digest.Digest) (map[digest.Digest][]digest.Digest, error) {
+	type indexResult struct {
+		dgst     digest.Digest
+		children []digest.Digest
+		err      error
+	}
+	limiter := th.Connections.Limiter(th.Concurrency)
+	queue := tunny.NewFunc(th.Concurrency.WithDefaults().Manifests, func(payload interface{}) interface{} {
+		dgst := payload.(digest.Digest)
+		release, err := limiter.Acquire(ctx, destHub)
+		if err != nil {
+			return &indexResult{dgst: dgst, err: err}
+		}
+		defer release()
+		m, err := manifest.Get(ctx, destHub, th.DestImage, dgst.String())
+		if err != nil {
+			return &indexResult{dgst: dgst, err: err}
+		}
+		res := &indexResult{dgst: dgst}
+		if m.IsIndex() {
+			for _, d := range m.Manifests {
+				res.children = append(res.children, d.Digest)
+			}
+		}
+		return res
+	})
+	defer queue.Close()
+	results := make(chan *indexResult)
+	for _, dgst := range digests {
+		go func(dgst digest.Digest) {
+			results <- queue.Process(dgst).(*indexResult)
+		}(dgst)
+	}
+	children := make(map[digest.Digest][]digest.Digest, len(digests))
+	var err error
+	for range digests {
+		res := <-results
+		if res.err != nil {
+			//Pruning is refused rather than risking deletion of manifests referenced by kept image index
+			err = fmt.Errorf("failed to read destination manifest %s: %w", res.dgst, res.err)
+			continue
+		}
+		children[res.dgst] = res.children
+	}
+	return children, err
+}
@@ -0,0 +1,92 @@
+package tags
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution/digest"
+	"github.com/vbaksa/promoter/report"
+)
+
+func testDigest(c string) digest.Digest {
+	return digest.Digest("sha256:" + strings.Repeat(c, 64))
+}
+
+func TestPruneDeletions(t *testing.T) {
+	index, amd64, arm64, other, stale := testDigest("1"), testDigest("2"), testDigest("3"), testDigest("4"), testDigest("5")
+	tests := []struct {
+		name       string
+		candidates map[string]digest.Digest
+		keep       map[digest.Digest]string
+		children   map[digest.Digest][]digest.Digest
+		deleted    []string
+	}{
+		{"stale tag", map[string]digest.Digest{"old": stale}, map[digest.Digest]string{index: "1.0"}, nil, []string{"old"}},
+		{"unknown digest", map[string]digest.Digest{"old": ""}, map[digest.Digest]string{index: "1.0"}, nil, nil},
+		{"shared manifest", map[string]digest.Digest{"alias": index, "old": stale}, map[digest.Digest]string{index: "1.0"}, nil, []string{"old"}},
+		{"child of kept index", map[string]digest.Digest{"1.0-amd64": amd64, "1.0-arm64": arm64}, map[digest.Digest]string{index: "1.0"},
+			map[digest.Digest][]digest.Digest{index: {amd64, arm64}}, nil},
+		{"child of other index", map[string]digest.Digest{"1.0-amd64": amd64, "old": other}, map[digest.Digest]string{index: "1.0"},
+			map[digest.Digest][]digest.Digest{index: {amd64}, other: {arm64}}, []string{"old"}},
+		{"nothing kept", map[string]digest.Digest{"a": amd64, "b": arm64}, nil, nil, []string{"a", "b"}},
+	}
+	th := &TagPush{DestImage: "app"}
+	for _, test := range tests {
+		candidates := make([]string, 0, len(test.candidates))
+		statuses := make(map[string]*tagStatus, len(test.candidates))
+		for tag, dgst := range test.candidates {
+			candidates = append(candidates, tag)
+			statuses[tag] = &tagStatus{tag: tag, destDigest: dgst}
+		}
+		sort.Strings(candidates)
+		deletions := th.pruneDeletions(candidates, statuses, test.keep, test.children)
+		deleted := make([]string, 0, len(deletions))
+		for _, tag := range deletions {
+			deleted = append(deleted, tag.Tag)
+			if tag.Digest != test.candidates[tag.Tag] {
+				t.Errorf("%s: tag %s digest %s, expected %s", test.name, tag.Tag, tag.Digest, test.candidates[tag.Tag])
+			}
+		}
+		if strings.Join(deleted, ",") != strings.Join(test.deleted, ",") {
+			t.Errorf("%s: deleted %v, expected %v", test.name, deleted, test.deleted)
+		}
+	}
+}
+
+func TestKeptDigests(t *testing.T) {
+	promoted, upToDate, existing := testDigest("a"), testDigest("b"), testDigest("c")
+	destTags := map[string]string{"1.0": "v1.0", "2.0": "v2.0", "3.0": "v3.0", "4.0": "v4.0"}
+	statuses := map[string]*tagStatus{
+		"1.0": {tag: "1.0", destDigest: existing},
+		"2.0": {tag: "2.0"},
+	}
+	result := &report.Result{
+		Tags:         []report.Tag{{Tag: "v3.0", Digest: promoted}},
+		UpToDateTags: []report.Tag{{Tag: "v4.0", Digest: upToDate}},
+	}
+	keep := keptDigests(destTags, statuses, result)
+	tests := []struct {
+		digest digest.Digest
+		tag    string
+	}{
+		{existing, "v1.0"},
+		{promoted, "v3.0"},
+		{upToDate, "v4.0"},
+	}
+	if len(keep) != len(tests) {
+		t.Errorf("kept %d digests, expected %d: %v", len(keep), len(tests), keep)
+	}
+	for _, test := range tests {
+		if keep[test.digest] != test.tag {
+			t.Errorf("%s: kept by %q, expected %q", test.digest, keep[test.digest], test.tag)
+		}
+	}
+	if pruneCandidates([]string{"1.0"}, statuses, keep) {
+		t.Errorf("tag referencing kept manifest reported as prune candidate")
+	}
+	statuses["old"] = &tagStatus{tag: "old", destDigest: testDigest("d")}
+	if !pruneCandidates([]string{"1.0", "old"}, statuses, keep) {
+		t.Errorf("tag referencing stale manifest not reported as prune candidate")
+	}
+}
@@ -0,0 +1,187 @@
+package tags
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Jeffail/tunny"
+	"github.com/heroku/docker-registry-client/registry"
+	"github.com/vbaksa/promoter/connection"
+	"github.com/vbaksa/promoter/manifest"
+	"github.com/vbaksa/promoter/semver"
+)
+
+const (
+	//LatestBySemver orders tags by semantic version, tags which are not semantic versions are rejected
+	LatestBySemver = "semver"
+	//LatestByCreated orders tags by creation time recorded in image configuration
+	LatestByCreated = "created"
+)
+
+//tagSelection records whether tag was selected and which filter rejected it
+type tagSelection struct {
+	tag        string
+	version    *semver.Version
+	created    time.Time
+	rejectedBy string
+}
+
+//selectTags applies tag filters in order: include regexp, exclude regexp, semver range and latest N. Every tag is returned
+//together with the filter which rejected it
+func (th *TagPush) selectTags(ctx context.Context, pullHubs []*registry.Registry, tags []string) ([]*tagSelection, error) {
+	var include, exclude *regexp.Regexp
+	var constraint *semver.Constraint
+	var err error
+	if th.TagRegexp != "" {
+		if include, err = regexp.Compile(th.TagRegexp); err != nil {
+			return nil, fmt.Errorf("failed to filter by provided tag regexp: %w", err)
+		}
+	}
+	if th.TagExclude != "" {
+		if exclude, err = regexp.Compile(th.TagExclude); err != nil {
+			return nil, fmt.Errorf("failed to filter by provided tag exclude regexp: %w", err)
+		}
+	}
+	if th.Semver != "" {
+		if constraint, err = semver.ParseConstraint(th.Semver); err != nil {
+			return nil, err
+		}
+	}
+	if th.Latest < 0 {
+		return nil, errors.New("number of latest tags can not be negative")
+	}
+	if th.LatestBy != "" && th.LatestBy != LatestBySemver && th.LatestBy != LatestByCreated {
+		return nil, errors.New("tags can be ordered by " + LatestBySemver + " or " + LatestByCreated + ", not " + th.LatestBy)
+	}
+	selections := make([]*tagSelection, 0, len(tags))
+	for _, tag := range tags {
+		s := &tagSelection{tag: tag}
+		s.version, _ = semver.Parse(tag)
+		switch {
+		case include != nil && !include.MatchString(tag):
+			s.rejectedBy = "tag regexp " + th.TagRegexp
+		case exclude != nil && exclude.MatchString(tag):
+			s.rejectedBy = "tag exclude " + th.TagExclude
+		case constraint != nil && s.version == nil:
+			s.rejectedBy = "semver " + th.Semver + " (not a semantic version)"
+		case constraint != nil && !constraint.Check(s.version):
+			s.rejectedBy = "semver " + th.Semver
+		}
+		selections = append(selections, s)
+	}
+	if th.Latest == 0 {
+		return selections, nil
+	}
+	candidates := make([]*tagSelection, 0, len(selections))
+	for _, s := range selections {
+		if s.rejectedBy == "" {
+			candidates = append(candidates, s)
+		}
+	}
+	rule := "latest " + strconv.Itoa(th.Latest)
+	if th.LatestBy == LatestByCreated {
+		th.resolveCreated(ctx, pullHubs, candidates)
+		ordered := make([]*tagSelection, 0, len(candidates))
+		for _, s := range candidates {
+			if s.rejectedBy == "" {
+				ordered = append(ordered, s)
+			}
+		}
+		sort.SliceStable(ordered, func(i, j int) bool {
+			if !ordered[i].created.Equal(ordered[j].created) {
+				return ordered[i].created.After(ordered[j].created)
+			}
+			return ordered[i].tag > ordered[j].tag
+		})
+		candidates = ordered
+	} else {
+		ordered := make([]*tagSelection, 0, len(candidates))
+		for _, s := range candidates {
+			if s.version == nil {
+				s.rejectedBy = rule + " (not a semantic version)"
+			} else {
+				ordered = append(ordered, s)
+			}
+		}
+		sort.SliceStable(ordered, func(i, j int) bool {
+			if c := ordered[i].version.Compare(ordered[j].version); c != 0 {
+				return c > 0
+			}
+			return ordered[i].tag > ordered[j].tag
+		})
+		candidates = ordered
+	}
+	for i := th.Latest; i < len(candidates); i++ {
+		candidates[i].rejectedBy = rule
+	}
+	return selections, nil
+}
+
+//resolveCreated reads creation time of tag images. Tags with unknown creation time are rejected
+func (th *TagPush) resolveCreated(ctx context.Context, pullHubs []*registry.Registry, selections []*tagSelection) {
+	fmt.Fprintln(th.out(), "Reading image creation time...")
+	limiter := th.Connections.Limiter(th.Concurrency)
+	queue := tunny.NewFunc(th.Concurrency.WithDefaults().Manifests, func(payload interface{}) interface{} {
+		s := payload.(*tagSelection)
+		if err := th.readCreated(ctx, limiter, pullHubs, s); err != nil {
+			s.rejectedBy = "latest " + strconv.Itoa(th.Latest) + " (creation time unknown: " + err.Error() + ")"
+		}
+		return s
+	})
+	defer queue.Close()
+	done := make(chan bool)
+	for _, s := range selections {
+		go func(s *tagSelection) {
+			queue.Process(s)
+			done <- true
+		}(s)
+	}
+	for range selections {
+		<-done
+	}
+}
+
+//readCreated reads creation time of tag image from the first pull registry which has it
+func (th *TagPush) readCreated(ctx context.Context, limiter *connection.HostLimiter, pullHubs []*registry.Registry, s *tagSelection) error {
+	release, err := limiter.Acquire(ctx, pullHubs...)
+	if err != nil {
+		return err
+	}
+	defer release()
+	img, hub, err := manifest.ResolveFrom(ctx, pullHubs, th.SrcImage, s.tag, th.Platforms)
+	if err != nil {
+		return err
+	}
+	s.created, err = manifest.Created(ctx, hub, th.SrcImage, img)
+	return err
+}
+
+//printSelection lists tags together with filters which rejected them. Destination tag is printed for renamed tags
+func printSelection(out io.Writer, selections []*tagSelection, destTags map[string]string) {
+	for _, s := range selections {
+		if s.rejectedBy == "" && destTags[s.tag] != s.tag {
+			fmt.Fprintf(out, "  selected  %s -> %s\n", s.tag, destTags[s.tag])
+		} else if s.rejectedBy == "" {
+			fmt.Fprintf(out, "  selected  %s\n", s.tag)
+		} else {
+			fmt.Fprintf(out, "  rejected  %s by %s\n", s.tag, s.rejectedBy)
+		}
+	}
+}
+
+//selectedTags returns tags passing all filters
+func selectedTags(selections []*tagSelection) []string {
+	tags := make([]string, 0, len(selections))
+	for _, s := range selections {
+		if s.rejectedBy == "" {
+			tags = append(tags, s.tag)
+		}
+	}
+	return tags
+}
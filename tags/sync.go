@@ -0,0 +1,128 @@
+package tags
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/vbaksa/promoter/connection"
+	"github.com/vbaksa/promoter/layer"
+	"github.com/vbaksa/promoter/report"
+)
+
+//SyncResult describes outcome of every promotion of the batch
+type SyncResult struct {
+	Entries []SyncEntry
+}
+
+//SyncEntry describes outcome of single promotion of the batch. Result is nil when promotion failed before any tag was processed
+type SyncEntry struct {
+	Source      string
+	Destination string
+	Result      *report.Result
+	Err         error
+	//Skipped is set when mirrored repository has no tags matching tag filters. Skipped promotion is not failed
+	Skipped bool
+}
+
+//Sync runs promotions one after another. Promotions share registry connections and blobs, so blob transferred once is mounted
+//by the following promotions into the same registry. Failed promotion does not stop the batch; error wraps report.ErrIncomplete
+//when any of the promotions failed. Progress of the batch and its summary are written to out, nothing is printed when nil
+func Sync(ctx context.Context, pushes []*TagPush, out io.Writer) (*SyncResult, error) {
+	return syncPushes(ctx, pushes, out, false)
+}
+
+//syncPushes runs promotions of the batch, see Sync. Promotions whose tag filters match no tags are skipped instead of failed when skipUnmatched is set
+func syncPushes(ctx context.Context, pushes []*TagPush, out io.Writer, skipUnmatched bool) (*SyncResult, error) {
+	if out == nil {
+		out = ioutil.Discard
+	}
+	connections := connection.NewPool()
+	blobs := layer.NewBlobIndex()
+	result := &SyncResult{}
+	for i, th := range pushes {
+		if th.Connections == nil {
+			th.Connections = connections
+		}
+		if th.Blobs == nil {
+			th.Blobs = blobs
+		}
+		entry := SyncEntry{
+			Source:      th.SrcRegistry + "/" + th.SrcImage,
+			Destination: th.DestRegistry + "/" + th.DestImage,
+		}
+		fmt.Fprintf(out, "[%d/%d] Promoting %s to %s\n", i+1, len(pushes), entry.Source, entry.Destination)
+		if err := ctx.Err(); err != nil {
+			entry.Err = err
+		} else {
+			entry.Result, entry.Err = th.PushTags(ctx)
+		}
+		if skipUnmatched && errors.Is(entry.Err, report.ErrNoMatchingTags) {
+			entry.Skipped, entry.Err = true, nil
+			fmt.Fprintln(out, "Skipped: "+report.ErrNoMatchingTags.Error())
+		}
+		if entry.Err != nil {
+			fmt.Fprintln(out, "Error: "+connection.Redact(entry.Err.Error()))
+		}
+		result.Entries = append(result.Entries, entry)
+	}
+	result.PrintSummary(out)
+	if failed := result.Failed(); failed > 0 {
+		return result, fmt.Errorf("%d of %d promotions failed: %w", failed, len(result.Entries), report.ErrIncomplete)
+	}
+	return result, nil
+}
+
+//Failed returns number of promotions which failed completely or partially
+func (r *SyncResult) Failed() int {
+	failed := 0
+	for _, entry := range r.Entries {
+		if entry.Err != nil {
+			failed++
+		}
+	}
+	return failed
+}
+
+//PrintSummary writes combined outcome of the batch
+func (r *SyncResult) PrintSummary(out io.Writer) {
+	fmt.Fprintln(out, "Summary:")
+	var tags, upToDateTags, deletedTags, failedTags, copied, mounted, skipped, skippedEntries int
+	var copiedSize, skippedSize int64
+	for _, entry := range r.Entries {
+		if entry.Skipped {
+			fmt.Fprintf(out, "  %-10s %s -> %s: %s\n", "SKIPPED", entry.Source, entry.Destination, report.ErrNoMatchingTags.Error())
+			skippedEntries++
+			continue
+		}
+		status := "OK"
+		if entry.Err != nil {
+			status = "FAILED"
+			if errors.Is(entry.Err, report.ErrIncomplete) {
+				status = "INCOMPLETE"
+			}
+		}
+		if entry.Result == nil {
+			fmt.Fprintf(out, "  %-10s %s -> %s: %s\n", status, entry.Source, entry.Destination, connection.Redact(entry.Err.Error()))
+			continue
+		}
+		res := entry.Result
+		fmt.Fprintf(out, "  %-10s %s -> %s: %d tags promoted, %d up-to-date, %d failed, %s transferred\n", status, entry.Source, entry.Destination,
+			len(res.Tags), len(res.UpToDateTags), len(res.FailedTags), humanize.IBytes(uint64(res.CopiedSize())))
+		tags = tags + len(res.Tags)
+		upToDateTags = upToDateTags + len(res.UpToDateTags)
+		deletedTags = deletedTags + len(res.DeletedTags)
+		failedTags = failedTags + len(res.FailedTags)
+		copied = copied + len(res.CopiedBlobs)
+		mounted = mounted + len(res.MountedBlobs)
+		skipped = skipped + len(res.SkippedBlobs)
+		copiedSize = copiedSize + res.CopiedSize()
+		skippedSize = skippedSize + res.SkippedSize()
+	}
+	fmt.Fprintf(out, "Promotions: %d, skipped: %d, failed: %d\n", len(r.Entries), skippedEntries, r.Failed())
+	fmt.Fprintf(out, "Tags promoted: %d, up-to-date: %d, deleted: %d, failed: %d\n", tags, upToDateTags, deletedTags, failedTags)
+	fmt.Fprintf(out, "Layers transferred: %d (%s), mounted: %d, already present: %d (%s)\n", copied, humanize.IBytes(uint64(copiedSize)), mounted, skipped, humanize.IBytes(uint64(skippedSize)))
+}
@@ -1,100 +1,210 @@
 package tags
 
 import (
+	"context"
 	"fmt"
-	"regexp"
-
-	"os"
-
-	manifestV1 "github.com/docker/distribution/manifest/schema1"
+	"io"
+	"io/ioutil"
 
 	"github.com/Jeffail/tunny"
-	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest/manifestlist"
 	"github.com/docker/libtrust"
+	"github.com/heroku/docker-registry-client/registry"
 	"github.com/vbaksa/promoter/connection"
-	"github.com/vbaksa/promoter/progressbar"
+	"github.com/vbaksa/promoter/layer"
+	"github.com/vbaksa/promoter/manifest"
+	"github.com/vbaksa/promoter/report"
 	"gopkg.in/cheggaaa/pb.v1"
-	"io/ioutil"
-	"log"
 )
 
 //TagPush holds image tags promotion structure
 type TagPush struct {
-	SrcRegistry  string
-	SrcImage     string
-	SrcUsername  string
-	SrcPassword  string
-	SrcInsecure  bool
+	SrcRegistry string
+	SrcImage    string
+	SrcUsername string
+	SrcPassword string
+	SrcInsecure bool
+	//SrcCAFile, SrcCertFile and SrcKeyFile configure source registry TLS. Certificates in certs.d/<host> directories are used as well
+	SrcCAFile    string
+	SrcCertFile  string
+	SrcKeyFile   string
 	DestRegistry string
 	DestImage    string
 	DestUsername string
 	DestPassword string
 	DestInsecure bool
-	TagRegexp    string
-	Debug        bool
+	//DestCAFile, DestCertFile and DestKeyFile configure destination registry TLS
+	DestCAFile   string
+	DestCertFile string
+	DestKeyFile  string
+	//SrcProxy and DestProxy override proxy environment variables, see connection.Config
+	SrcProxy  string
+	DestProxy string
+	//SrcMirrors lists pull mirrors tried before source registry e.g. mirror.corp for Docker Hub images
+	SrcMirrors []string
+	//TagRegexp selects tags matching regexp, TagExclude rejects tags matching regexp
+	TagRegexp  string
+	TagExclude string
+	//Semver selects tags which are semantic versions within range e.g. ">=1.4 <2.0"
+	Semver string
+	//Latest selects newest N tags passing other filters, ordered by LatestBy: LatestBySemver (default) or LatestByCreated
+	Latest   int
+	LatestBy string
+	//DryRun lists selected and rejected tags without promoting them
+	DryRun bool
+	//Force pushes tags even when destination tag already holds the same manifest
+	Force bool
+	//Prune deletes destination tags which are not among promoted tags. Pruning is refused when it would delete more than PruneMax tags (no limit when zero)
+	Prune    bool
+	PruneMax int
+	//Platforms limits image index promotion to specified platforms. All platforms are promoted when empty
+	Platforms []manifestlist.PlatformSpec
+	//InsecureRegistries lists registry hosts and CIDR networks which may be reached over plain HTTP when registry URL has no scheme
+	InsecureRegistries []string
+	//Transfer tunes layer transfer e.g. upload chunk size
+	Transfer layer.Options
+	//Retry configures retries of registry requests failing with transient errors
+	Retry connection.RetryPolicy
+	//Concurrency sets number of workers of manifest, layer check and upload pools
+	Concurrency connection.Concurrency
+	//TagMapping holds destination tag names keyed by source tag. Tags which are not mapped keep their name
+	TagMapping map[string]string
+	//TagRewrite is sed like rule applied to tags which are not listed in TagMapping e.g. s/^rc-(.*)$/\1/
+	TagRewrite string
+	//TagTemplate is Go template of destination tag applied after TagRewrite e.g. {{.Tag}}-prod. See tagTemplateData for available fields
+	TagTemplate string
+	//Connections shares registry connections with other promotions of the process. New connections are opened when nil
+	Connections *connection.Pool
+	//Blobs shares blobs promoted by other promotions of the process, so they are mounted instead of transferred again
+	Blobs *layer.BlobIndex
+	//Output receives progress messages and progress bars, e.g. os.Stdout. Nothing is printed when nil
+	Output io.Writer
 }
 type manifestGetResult struct {
-	manifest manifestV1.SignedManifest
-	tag      string
-	err      error
+	image *manifest.Image
+	//hub is registry manifest was pulled from, either pull mirror or source registry
+	hub *registry.Registry
+	tag string
+	err error
+	//blobFailed is set when manifest was retrieved, but some of its blobs failed to upload
+	blobFailed bool
 }
 type layerCheck struct {
-	layer       manifestV1.FSLayer
+	layer       digest.Digest
 	size        int64
 	remoteExist bool
+	mounted     bool
 	err         error
 }
 type uploadResult struct {
-	layer manifestV1.FSLayer
+	layer digest.Digest
 	err   error
 }
 type manifestDeployResult struct {
-	destManifest manifestV1.SignedManifest
+	tag          string
+	srcDigest    digest.Digest
+	destManifest *manifest.Manifest
+	children     []*manifest.Manifest
 	err          error
 }
 
-//PushTags promotes all specified image tags.
-func (th *TagPush) PushTags() {
-	if !th.Debug {
-		log.SetOutput(ioutil.Discard)
+//PushTags promotes all specified image tags. Result is returned even when some of the tags failed,
+//in which case error wraps report.ErrIncomplete
+func (th *TagPush) PushTags(ctx context.Context) (*report.Result, error) {
+	fmt.Fprintln(th.out(), "Preparing tags push")
+	//Connections are pooled even for single promotion, so all pools share registry host limits
+	if th.Connections == nil {
+		th.Connections = connection.NewPool()
 	}
-	fmt.Println("Preparing tags push")
-	srcHub, destHub := connection.InitConnection(th.SrcRegistry, th.SrcUsername, th.SrcPassword, th.SrcInsecure, th.DestRegistry, th.DestUsername, th.DestPassword, th.DestInsecure)
-	fmt.Println("Source Image: " + th.SrcImage)
-	fmt.Println("Destination image: " + th.DestImage)
+	concurrency := th.Concurrency.WithDefaults()
+	limiter := th.Connections.Limiter(concurrency)
+	srcHub, destHub, err := th.Connections.InitConnection(th.srcConfig(), th.destConfig())
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintln(th.out(), "Source Image: "+th.SrcImage)
+	fmt.Fprintln(th.out(), "Destination image: "+th.DestImage)
 	tags, err := srcHub.Tags(th.SrcImage)
 	if err != nil {
-		fmt.Println("Error occurred while trying to get Source Image Tags")
-		fmt.Println("Error: " + err.Error())
-		os.Exit(1)
+		return nil, fmt.Errorf("failed to get source image %s tags: %w", th.SrcImage, err)
 	}
 
 	totalTags := len(tags)
 
-	fmt.Printf("Source image contains %d tags\n", totalTags)
+	fmt.Fprintf(th.out(), "Source image contains %d tags\n", totalTags)
 
-	if len(th.TagRegexp) > 0 {
-		tags, err = filterByVersionSelector(tags, th.TagRegexp)
-		if err != nil {
-			fmt.Printf("Failed to filter by provided Tag regexp. Error: %q \n", err)
-			os.Exit(1)
-		} else {
-			fmt.Printf("Tag regexp matched %d images \n", len(tags))
+	//Tags are listed by source registry, while manifests and layers are pulled from mirrors first
+	pullHubs := append(th.Connections.ConnectMirrors(th.srcConfig()), srcHub)
+	selections, err := th.selectTags(ctx, pullHubs, tags)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range selections {
+		if s.rejectedBy != "" {
+			srcHub.Logf("tags.select tag=%s rejected_by=%q", s.tag, s.rejectedBy)
+		}
+	}
+	tags = selectedTags(selections)
+	//Tag mapping collisions are reported before anything is transferred
+	destTags, err := th.mapTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	if th.DryRun {
+		printSelection(th.out(), selections, destTags)
+		fmt.Fprintf(th.out(), "Selected %d of %d tags \n", len(tags), totalTags)
+		result := &report.Result{}
+		if th.Prune {
+			return result, th.pruneTags(ctx, destHub, destTags, th.compareTags(ctx, nil, destHub, tags, destTags), nil, result)
 		}
+		return result, nil
+	}
+	if len(tags) < totalTags {
+		fmt.Fprintf(th.out(), "Tag filters selected %d of %d tags \n", len(tags), totalTags)
 		if len(tags) == 0 {
-			fmt.Println("Image Tag Regexp didn't matched any tags")
-			os.Exit(1)
+			return nil, report.ErrNoMatchingTags
 		}
 	}
 
-	layers := make([]manifestV1.FSLayer, 0)
-	manifests := make([]manifestGetResult, 0)
-	//TO-DO parametrize number of connections
-	poolSize := 5
+	//Tags whose destination manifest matches the source one are skipped entirely
+	fmt.Fprintln(th.out(), "Comparing source and destination manifests...")
+	statuses := th.compareTags(ctx, srcHub, destHub, tags, destTags)
+	upToDateTags := make([]report.Tag, 0)
+	pendingTags := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		status := statuses[tag]
+		if !th.Force && status.srcDigest != "" && status.upToDate(status.srcDigest) {
+			upToDateTags = append(upToDateTags, report.Tag{Image: th.DestImage, Tag: destTags[tag], SourceDigest: status.srcDigest, Digest: status.destDigest})
+			continue
+		}
+		pendingTags = append(pendingTags, tag)
+	}
+	tags = pendingTags
+	if len(tags) == 0 {
+		printTagCounts(th.out(), len(upToDateTags), statuses, tags)
+		fmt.Fprintln(th.out(), "All tags are up-to-date")
+		result := &report.Result{UpToDateTags: upToDateTags}
+		if th.Prune {
+			return result, th.pruneTags(ctx, destHub, destTags, statuses, nil, result)
+		}
+		return result, nil
+	}
 
-	manifestGetQueue := tunny.NewFunc(poolSize, func(payload interface{}) interface{} {
+	layers := make([]digest.Digest, 0)
+	manifests := make([]manifestGetResult, 0)
+	manifestGetQueue := tunny.NewFunc(concurrency.Manifests, func(payload interface{}) interface{} {
 		tag := payload.(string)
-		manifest, err := srcHub.Manifest(th.SrcImage, tag)
+		release, err := limiter.Acquire(ctx, pullHubs...)
+		if err != nil {
+			return &manifestGetResult{
+				err: err,
+				tag: tag,
+			}
+		}
+		defer release()
+		srcImage, hub, err := manifest.ResolveFrom(ctx, pullHubs, th.SrcImage, tag, th.Platforms)
 		if err != nil {
 			return &manifestGetResult{
 				err: err,
@@ -102,9 +212,10 @@ func (th *TagPush) PushTags() {
 			}
 		}
 		return &manifestGetResult{
-			manifest: *manifest,
-			tag:      tag,
-			err:      nil,
+			image: srcImage,
+			hub:   hub,
+			tag:   tag,
+			err:   nil,
 		}
 	})
 	defer manifestGetQueue.Close()
@@ -118,6 +229,7 @@ func (th *TagPush) PushTags() {
 		}(tags[i])
 	}
 	manifestGetProgressBar := pb.New(len(tags)).SetUnits(pb.U_NO)
+	manifestGetProgressBar.Output = th.out()
 	manifestGetProgressBar.Start()
 	//Pull manifest
 	for i := 0; i < len(tags); i++ {
@@ -127,43 +239,90 @@ func (th *TagPush) PushTags() {
 	}
 	manifestGetProgressBar.Finish()
 
+	//Source digest of filtered image indexes is known only once manifest is downloaded
+	pendingManifests := make([]manifestGetResult, 0, len(manifests))
+	pendingTags = make([]string, 0, len(manifests))
+	for _, m := range manifests {
+		status := statuses[m.tag]
+		if m.err == nil && !th.Force && status.upToDate(m.image.Manifest.Digest) {
+			upToDateTags = append(upToDateTags, report.Tag{Image: th.DestImage, Tag: destTags[m.tag], SourceDigest: m.image.Manifest.Digest, Digest: status.destDigest})
+			continue
+		}
+		pendingManifests = append(pendingManifests, m)
+		pendingTags = append(pendingTags, m.tag)
+	}
+	manifests = pendingManifests
+	printTagCounts(th.out(), len(upToDateTags), statuses, pendingTags)
+
+	//Blob sizes declared by manifests are used to verify transferred data. Blobs are pulled from registry which served the manifest,
+	//falling back to the other pull registries when it does not have the blob
+	blobSizes := make(map[digest.Digest]int64)
+	blobHubs := make(map[digest.Digest][]*registry.Registry)
 	for i := 0; i < len(manifests); i++ {
-		layers = append(layers, manifests[i].manifest.FSLayers...)
+		if manifests[i].err == nil {
+			layers = append(layers, manifests[i].image.Blobs()...)
+			for blob, size := range manifests[i].image.BlobSizes() {
+				blobSizes[blob] = size
+			}
+			for _, blob := range manifests[i].image.Blobs() {
+				if _, ok := blobHubs[blob]; !ok {
+					blobHubs[blob] = layer.SourceHubs(manifests[i].hub, pullHubs)
+				}
+			}
+		}
 	}
-	fmt.Printf("Total number of layers %d \n", len(layers))
-	uniqueLayers := make([]manifestV1.FSLayer, 0)
+	fmt.Fprintf(th.out(), "Total number of layers %d \n", len(layers))
+	uniqueLayers := make([]digest.Digest, 0)
 
 	for _, layer := range layers {
 		uniqueLayers = appendIfMissing(uniqueLayers, layer)
 	}
 	if len(layers) > len(uniqueLayers) {
 		duplicateLayerCount := len(layers) - len(uniqueLayers)
-		fmt.Printf("Reducing transfer size by skipping duplicate layers. Duplicate layers skipped: %d \n", duplicateLayerCount)
+		fmt.Fprintf(th.out(), "Reducing transfer size by skipping duplicate layers. Duplicate layers skipped: %d \n", duplicateLayerCount)
 	}
-	fmt.Println("Retrieving layer metadata and optimising transfer..")
+	fmt.Fprintln(th.out(), "Retrieving layer metadata and optimising transfer..")
 
-	layerSizeGetQueue := tunny.NewFunc(10, func(payload interface{}) interface{} {
-		layer := payload.(manifestV1.FSLayer)
-		metadata, err := srcHub.LayerMetadata(th.SrcImage, layer.BlobSum)
+	layerSizeGetQueue := tunny.NewFunc(concurrency.Metadata, func(payload interface{}) interface{} {
+		blob := payload.(digest.Digest)
+		release, err := limiter.Acquire(ctx, blobHubs[blob]...)
+		if err != nil {
+			return &layerCheck{
+				layer: blob,
+				err:   err,
+			}
+		}
+		defer release()
+		metadata, err := layer.BlobMetadata(blobHubs[blob], th.SrcImage, blob)
 		if err != nil {
 			return &layerCheck{
-				layer: layer,
+				layer: blob,
 				err:   err,
 			}
 		}
+		size := metadata.Size
+		if declared, ok := blobSizes[blob]; ok {
+			size = declared
+		}
 		return &layerCheck{
-			layer: layer,
-			size:  metadata.Size,
+			layer: blob,
+			size:  size,
 			err:   nil,
 		}
 	})
-	layerExistQueue := tunny.NewFunc(5, func(payload interface{}) interface{} {
+	layerExistQueue := tunny.NewFunc(concurrency.Exists, func(payload interface{}) interface{} {
 		layerCheck := payload.(*layerCheck)
 		//If previous operation failed then pass layer exist check
 		if layerCheck.err != nil {
 			return layerCheck
 		}
-		exist, _ := destHub.HasLayer(th.DestImage, layerCheck.layer.BlobSum)
+		release, err := limiter.Acquire(ctx, destHub)
+		if err != nil {
+			layerCheck.err = err
+			return layerCheck
+		}
+		defer release()
+		exist, _ := destHub.HasLayer(th.DestImage, layerCheck.layer)
 		layerCheck.remoteExist = exist
 		return layerCheck
 	})
@@ -171,11 +330,12 @@ func (th *TagPush) PushTags() {
 	defer layerExistQueue.Close()
 
 	layerCheckProgressBar := pb.New(len(uniqueLayers)).SetUnits(pb.U_NO)
+	layerCheckProgressBar.Output = th.out()
 	layerCheckProgressBar.Start()
 
 	layerCheckChannel := make(chan *layerCheck)
 	for i := 0; i < len(uniqueLayers); i++ {
-		go func(layer manifestV1.FSLayer) {
+		go func(layer digest.Digest) {
 			result := layerSizeGetQueue.Process(layer)
 			result = layerExistQueue.Process(result.(*layerCheck))
 			layerCheckChannel <- result.(*layerCheck)
@@ -190,32 +350,56 @@ func (th *TagPush) PushTags() {
 	}
 	layerCheckProgressBar.Finish()
 
-	fmt.Println("Transferring layers...")
+	//Layers of the same registry are mounted instead of transferred
+	missingLayers := make([]digest.Digest, 0)
+	for _, layerCheckResult := range layerCheckResults {
+		if layerCheckResult.needsUpload() {
+			missingLayers = append(missingLayers, layerCheckResult.layer)
+		}
+	}
+	mountedLayers, remainingLayers := layer.MountLayers(ctx, destHub, th.DestImage, srcHub, th.SrcImage, missingLayers, concurrency.Exists, limiter)
+	th.printMounted(len(mountedLayers), th.SrcImage)
+	//Layers promoted into another repository of destination registry by this process are mounted from there
+	promotedLayers := make(map[string][]digest.Digest)
+	for _, remaining := range remainingLayers {
+		if repository, ok := th.Blobs.Repository(destHub, remaining); ok && repository != th.DestImage {
+			promotedLayers[repository] = append(promotedLayers[repository], remaining)
+		}
+	}
+	for repository, blobs := range promotedLayers {
+		mounted, _ := layer.MountLayers(ctx, destHub, th.DestImage, destHub, repository, blobs, concurrency.Exists, limiter)
+		th.printMounted(len(mounted), repository)
+		mountedLayers = append(mountedLayers, mounted...)
+	}
+	for _, mounted := range mountedLayers {
+		for i := range layerCheckResults {
+			if layerCheckResults[i].layer == mounted {
+				layerCheckResults[i].mounted = true
+			}
+		}
+	}
+
+	fmt.Fprintln(th.out(), "Transferring layers...")
 	var totalReader = make(chan int64)
 	uploadResultChannel := make(chan *uploadResult)
 	uploadResults := make([]uploadResult, 0)
-	uploadQueue := tunny.NewFunc(poolSize, func(payload interface{}) interface{} {
-		upload := payload.(manifestV1.FSLayer)
-		reader, err := srcHub.DownloadLayer(th.SrcImage, upload.BlobSum)
-		if reader != nil {
-			defer reader.Close()
-		}
-		var err2 error
-		if totalReader != nil {
-			rd := &progressbar.PassThru{ReadCloser: reader, Total: &totalReader}
-			err2 = destHub.UploadLayer(th.DestImage, upload.BlobSum, rd)
-		} else {
-			err2 = destHub.UploadLayer(th.DestImage, upload.BlobSum, reader)
-		}
+	uploadQueue := tunny.NewFunc(concurrency.Uploads, func(payload interface{}) interface{} {
+		upload := payload.(distribution.Descriptor)
+		release, err := limiter.Acquire(ctx, append([]*registry.Registry{destHub}, blobHubs[upload.Digest]...)...)
 		if err != nil {
-			fmt.Printf("Error occurred while uploading layer:  %s. Error: %s \n", upload.BlobSum, err.Error())
+			return &uploadResult{
+				layer: upload.Digest,
+				err:   err,
+			}
 		}
-		if err2 != nil {
-			fmt.Printf("Error occurred while uploading layer:  %s. Error: %s \n", upload.BlobSum, err2.Error())
+		defer release()
+		err = layer.UploadLayerFrom(ctx, destHub, th.DestImage, blobHubs[upload.Digest], th.SrcImage, upload, &totalReader, th.Transfer)
+		if err != nil {
+			fmt.Fprintf(th.out(), "Error occurred while uploading layer:  %s. Error: %s \n", upload.Digest, err.Error())
 		}
 
 		return &uploadResult{
-			layer: upload,
+			layer: upload.Digest,
 			err:   err,
 		}
 	})
@@ -224,85 +408,138 @@ func (th *TagPush) PushTags() {
 	//Get total transfer size
 	var transferSize int64
 	for _, layerCheckResult := range layerCheckResults {
-		if !layerCheckResult.remoteExist {
+		if layerCheckResult.needsUpload() {
 			transferSize = transferSize + layerCheckResult.size
 		}
 	}
-	uploadProgressBar := pb.New64(transferSize * 2).SetUnits(pb.U_BYTES)
+	//Layer data is counted once, so the bar shows effective transfer rate
+	uploadProgressBar := pb.New64(transferSize).SetUnits(pb.U_BYTES)
+	uploadProgressBar.ShowSpeed = true
+	uploadProgressBar.Output = th.out()
 	uploadProgressBar.Start()
 
 	//Submit upload
 	for _, layerCheckResult := range layerCheckResults {
-		if layerCheckResult.err == nil && !layerCheckResult.remoteExist {
-			go func(layer manifestV1.FSLayer) {
-				result := uploadQueue.Process(layer)
+		if layerCheckResult.needsUpload() {
+			go func(blob distribution.Descriptor) {
+				result := uploadQueue.Process(blob)
 				uploadResultChannel <- result.(*uploadResult)
-			}(layerCheckResult.layer)
+			}(distribution.Descriptor{Digest: layerCheckResult.layer, Size: layerCheckResult.size})
 		}
 		if layerCheckResult.err != nil {
-			fmt.Printf("Failed to retrieve layer %s data. Error: %s \n", layerCheckResult.layer.BlobSum, layerCheckResult.err.Error())
+			fmt.Fprintf(th.out(), "Failed to retrieve layer %s data. Error: %s \n", layerCheckResult.layer, layerCheckResult.err.Error())
 		}
 	}
 	//Constantly update progress bar
 	go func() {
-		for {
-			t := <-totalReader
-			uploadProgressBar.Add64(t * 2)
+		for t := range totalReader {
+			uploadProgressBar.Add64(t)
 		}
 	}()
 
 	//Collect upload results
 	for _, layerCheckResult := range layerCheckResults {
-		if layerCheckResult.err == nil && !layerCheckResult.remoteExist {
+		if layerCheckResult.needsUpload() {
 			res := <-uploadResultChannel
 			uploadResults = append(uploadResults, *res)
 		}
 	}
+	close(totalReader)
 	uploadProgressBar.Finish()
 
+	result := &report.Result{UpToDateTags: upToDateTags}
+	uploaded := make(map[digest.Digest]bool)
+	failedUploads := make(map[digest.Digest]error)
+	for _, uploadResult := range uploadResults {
+		if uploadResult.err == nil {
+			uploaded[uploadResult.layer] = true
+		} else {
+			failedUploads[uploadResult.layer] = uploadResult.err
+		}
+	}
+	for _, layerCheckResult := range layerCheckResults {
+		descriptor := distribution.Descriptor{Digest: layerCheckResult.layer, Size: layerCheckResult.size}
+		if layerCheckResult.remoteExist {
+			result.SkippedBlobs = append(result.SkippedBlobs, descriptor)
+		} else if layerCheckResult.mounted {
+			result.MountedBlobs = append(result.MountedBlobs, descriptor)
+		} else if uploaded[layerCheckResult.layer] {
+			result.CopiedBlobs = append(result.CopiedBlobs, descriptor)
+		} else {
+			continue
+		}
+		th.Blobs.Add(destHub, th.DestImage, layerCheckResult.layer)
+	}
+
 	//Deploy manifest files
-	fmt.Println("Uploading Manifest files...")
+	fmt.Fprintln(th.out(), "Uploading Manifest files...")
 	key, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		return result, fmt.Errorf("failed to generate image signing key: %w", err)
+	}
 	manifestDeployResultChannel := make(chan *manifestDeployResult)
 	manifestDeployResults := make([]manifestDeployResult, 0)
-	manifestDeployQueue := tunny.NewFunc(poolSize, func(payload interface{}) interface{} {
-		srcManifest := payload.(manifestV1.SignedManifest)
-		destManifest := &manifestV1.Manifest{
-			Versioned: manifest.Versioned{
-				SchemaVersion: 1,
-			},
-			Name:         th.DestImage,
-			Tag:          srcManifest.Tag,
-			Architecture: srcManifest.Architecture,
-			FSLayers:     srcManifest.FSLayers,
-			History:      srcManifest.History,
-		}
-		signedDestManifest, err := manifestV1.Sign(destManifest, key)
+	manifestDeployQueue := tunny.NewFunc(concurrency.Manifests, func(payload interface{}) interface{} {
+		src := payload.(manifestGetResult)
+		if err := ctx.Err(); err != nil {
+			return &manifestDeployResult{
+				tag: src.tag,
+				err: err,
+			}
+		}
+		release, err := limiter.Acquire(ctx, destHub)
 		if err != nil {
 			return &manifestDeployResult{
-				destManifest: *signedDestManifest,
-				err:          err,
+				tag: src.tag,
+				err: err,
+			}
+		}
+		defer release()
+		destTag := destTags[src.tag]
+		destManifest, err := manifest.Sign(src.image.Manifest, th.DestImage, destTag, key)
+		if err != nil {
+			return &manifestDeployResult{
+				tag: src.tag,
+				err: err,
 			}
 		}
-		err = destHub.PutManifest(th.DestImage, srcManifest.Tag, signedDestManifest)
+		err = manifest.Push(ctx, destHub, th.DestImage, destTag, &manifest.Image{Manifest: destManifest, Children: src.image.Children})
 
 		return &manifestDeployResult{
-			destManifest: *signedDestManifest,
+			tag:          src.tag,
+			srcDigest:    src.image.Manifest.Digest,
+			destManifest: destManifest,
+			children:     src.image.Children,
 			err:          err,
 		}
 	})
 	defer manifestDeployQueue.Close()
 
+	//Tags referencing blobs which failed to upload (e.g. blob did not match its digest) are not deployed
+	for i := 0; i < len(manifests); i++ {
+		if manifests[i].err != nil {
+			continue
+		}
+		for _, blob := range manifests[i].image.Blobs() {
+			if err, failed := failedUploads[blob]; failed {
+				manifests[i].err = err
+				manifests[i].blobFailed = true
+				break
+			}
+		}
+	}
+
 	for i := 0; i < len(manifests); i++ {
 		if manifests[i].err == nil {
-			go func(manifest manifestV1.SignedManifest) {
-				result := manifestDeployQueue.Process(manifest)
+			go func(src manifestGetResult) {
+				result := manifestDeployQueue.Process(src)
 				manifestDeployResultChannel <- result.(*manifestDeployResult)
-			}(manifests[i].manifest)
+			}(manifests[i])
 
 		}
 	}
 	manifestDeployProgressBar := pb.New(len(manifests)).SetUnits(pb.U_NO)
+	manifestDeployProgressBar.Output = th.out()
 	manifestDeployProgressBar.Start()
 
 	//Collect manifest deployment results
@@ -315,26 +552,62 @@ func (th *TagPush) PushTags() {
 	}
 	manifestDeployProgressBar.Finish()
 	//Report failed deployments
-	var errorsFound bool
 	for i := 0; i < len(manifests); i++ {
-		if manifests[i].err != nil {
-			fmt.Printf("Failed to push image %s because unable to retrieve image manifest. Error: %s \n", manifests[i].manifest.Name+":"+manifests[i].tag, manifests[i].err.Error())
-			errorsFound = true
+		if manifests[i].blobFailed {
+			fmt.Fprintf(th.out(), "Failed to push image %s because its layer failed to upload. Error: %s \n", th.DestImage+":"+destTags[manifests[i].tag], manifests[i].err.Error())
+			result.FailedTags = append(result.FailedTags, report.Tag{
+				Image:        th.DestImage,
+				Tag:          destTags[manifests[i].tag],
+				SourceDigest: manifests[i].image.Manifest.Digest,
+				Err:          fmt.Errorf("failed to upload image %s:%s layer: %w", th.SrcImage, manifests[i].tag, manifests[i].err),
+			})
+		} else if manifests[i].err != nil {
+			fmt.Fprintf(th.out(), "Failed to push image %s because unable to retrieve image manifest. Error: %s \n", th.SrcImage+":"+manifests[i].tag, manifests[i].err.Error())
+			result.FailedTags = append(result.FailedTags, report.Tag{
+				Image: th.DestImage,
+				Tag:   destTags[manifests[i].tag],
+				Err:   fmt.Errorf("failed to retrieve image %s:%s manifest: %w", th.SrcImage, manifests[i].tag, manifests[i].err),
+			})
 		}
 	}
+	//Child manifests of promoted image indexes are protected from pruning
+	promoted := make(map[digest.Digest][]digest.Digest)
 	for _, manifestDeployResult := range manifestDeployResults {
+		tag := report.Tag{
+			Image:        th.DestImage,
+			Tag:          destTags[manifestDeployResult.tag],
+			SourceDigest: manifestDeployResult.srcDigest,
+		}
 		if manifestDeployResult.err != nil {
-			fmt.Printf("Failed to push image %s because unable to deploy image manifest. Error: %s \n", manifestDeployResult.destManifest.Name+":"+manifestDeployResult.destManifest.Tag, manifestDeployResult.err.Error())
-			errorsFound = true
+			fmt.Fprintf(th.out(), "Failed to push image %s because unable to deploy image manifest. Error: %s \n", th.DestImage+":"+tag.Tag, manifestDeployResult.err.Error())
+			tag.Err = fmt.Errorf("failed to deploy image %s:%s manifest: %w", th.DestImage, tag.Tag, manifestDeployResult.err)
+			result.FailedTags = append(result.FailedTags, tag)
+			continue
+		}
+		tag.Digest = manifestDeployResult.destManifest.Digest
+		result.Tags = append(result.Tags, tag)
+		promoted[tag.Digest] = make([]digest.Digest, 0, len(manifestDeployResult.children))
+		for _, child := range manifestDeployResult.children {
+			promoted[tag.Digest] = append(promoted[tag.Digest], child.Digest)
 		}
 	}
-	fmt.Println("All done!")
-	if errorsFound {
-		os.Exit(1)
+	if th.Prune {
+		if err := th.pruneTags(ctx, destHub, destTags, statuses, promoted, result); err != nil {
+			return result, err
+		}
+	}
+	fmt.Fprintln(th.out(), "All done!")
+	if len(result.FailedTags) > 0 {
+		return result, fmt.Errorf("%d of %d tags failed to promote: %w", len(result.FailedTags), len(manifests), report.ErrIncomplete)
 	}
-	os.Exit(0)
+	return result, nil
+}
+
+//needsUpload reports whether layer data has to be transferred into destination registry
+func (lc *layerCheck) needsUpload() bool {
+	return lc.err == nil && !lc.remoteExist && !lc.mounted
 }
-func appendIfMissing(slice []manifestV1.FSLayer, i manifestV1.FSLayer) []manifestV1.FSLayer {
+func appendIfMissing(slice []digest.Digest, i digest.Digest) []digest.Digest {
 	for _, ele := range slice {
 		if ele == i {
 			return slice
@@ -342,17 +615,55 @@ func appendIfMissing(slice []manifestV1.FSLayer, i manifestV1.FSLayer) []manifes
 	}
 	return append(slice, i)
 }
-func filterByVersionSelector(tags []string, filter string) (tagsFiltered []string, err error) {
-
-	filteredTags := make([]string, 0)
-	r, err := regexp.Compile(filter)
-	if err == nil {
-		for _, tag := range tags {
-			match := r.MatchString(tag)
-			if match {
-				filteredTags = append(filteredTags, tag)
-			}
-		}
+
+//out returns writer of progress messages
+func (th *TagPush) out() io.Writer {
+	if th.Output == nil {
+		return ioutil.Discard
+	}
+	return th.Output
+}
+
+//printMounted prints number of layers mounted from repository
+func (th *TagPush) printMounted(mounted int, repository string) {
+	if mounted > 0 {
+		fmt.Fprintf(th.out(), "Mounted %d layers from %s without transferring layer data \n", mounted, repository)
+	}
+}
+
+func (th *TagPush) srcConfig() connection.Config {
+	return connection.Config{
+		URL:      th.SrcRegistry,
+		Username: th.SrcUsername,
+		Password: th.SrcPassword,
+		TLS: connection.TLSConfig{
+			Insecure: th.SrcInsecure,
+			CAFile:   th.SrcCAFile,
+			CertFile: th.SrcCertFile,
+			KeyFile:  th.SrcKeyFile,
+		},
+		InsecureRegistries: th.InsecureRegistries,
+		Proxy:              th.SrcProxy,
+		Retry:              th.Retry,
+		Mirrors:            th.SrcMirrors,
+		Output:             th.Output,
+	}
+}
+
+func (th *TagPush) destConfig() connection.Config {
+	return connection.Config{
+		URL:      th.DestRegistry,
+		Username: th.DestUsername,
+		Password: th.DestPassword,
+		TLS: connection.TLSConfig{
+			Insecure: th.DestInsecure,
+			CAFile:   th.DestCAFile,
+			CertFile: th.DestCertFile,
+			KeyFile:  th.DestKeyFile,
+		},
+		InsecureRegistries: th.InsecureRegistries,
+		Proxy:              th.DestProxy,
+		Retry:              th.Retry,
+		Output:             th.Output,
 	}
-	return filteredTags, err
 }